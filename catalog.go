@@ -0,0 +1,100 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// A CatalogEntry describes one registry entry and its pins.
+type CatalogEntry struct {
+	Name    string            `json:"name"`
+	Help    string            `json:"help,omitempty"`
+	Circuit bool              `json:"circuit,omitempty"`
+	Inputs  []CatalogPin      `json:"inputs"`
+	Outputs []CatalogPin      `json:"outputs"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Plugin  string            `json:"plugin,omitempty"` // file it was loaded from
+	Error   string            `json:"error,omitempty"`  // definition can't be used
+}
+
+// A CatalogPin describes one pin of a gadget or circuit.
+type CatalogPin struct {
+	Name     string `json:"name"`
+	Map      bool   `json:"map,omitempty"`      // output map, used as "Pin:x"
+	Optional bool   `json:"optional,omitempty"` // input which may be left open
+}
+
+// Catalog instantiates each entry in the registry to find out which pins it
+// has. Circuits loaded from definitions are not instantiated, their pins are
+// found through their labels, which are included as well. A definition which
+// can't be parsed is listed with an error. Entries are sorted by name, and can
+// be encoded as JSON.
+func Catalog(registry map[string]func() Circuitry) []CatalogEntry {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	l := &linter{
+		registry: registry,
+		defs:     map[string]*config{},
+		pins:     map[string]map[string]pinInfo{},
+	}
+	entries := []CatalogEntry{}
+	for _, name := range names {
+		entry := CatalogEntry{
+			Name:    name,
+			Help:    Help[name],
+			Plugin:  pluginOf(name),
+			Inputs:  []CatalogPin{},
+			Outputs: []CatalogPin{},
+		}
+		var pins map[string]pinInfo
+		if raw := circuitDefs[name]; raw != nil {
+			def, err := parseDefinition(raw, nil)
+			if err != nil {
+				entry.Error = err.Error()
+				entries = append(entries, entry)
+				continue
+			}
+			if def.Process == nil {
+				entry.Circuit = true
+				for _, lbl := range def.Labels {
+					if entry.Labels == nil {
+						entry.Labels = map[string]string{}
+					}
+					entry.Labels[lbl.External] = lbl.Internal
+				}
+			}
+			pins = l.pinsOfType(name)
+		} else {
+			cy := registry[name]()
+			if c, ok := cy.(*Circuit); ok {
+				entry.Circuit = true
+				entry.Labels = c.labels
+			}
+			pins = pinsOf(cy)
+		}
+		for _, pin := range sortedKeys(pins) {
+			info := pins[pin]
+			if info.kind == pinInput {
+				entry.Inputs = append(entry.Inputs,
+					CatalogPin{Name: pin, Optional: info.optional})
+			} else {
+				entry.Outputs = append(entry.Outputs,
+					CatalogPin{Name: pin, Map: info.kind == pinOutputMap})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Print the catalog of all registry entries in indented JSON format.
+func PrintCatalog() {
+	data, err := json.MarshalIndent(Catalog(Registry), "", "  ")
+	Check(err)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,93 @@
+package flow_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleCatalog() {
+	registry := map[string]func() flow.Circuitry{
+		"Dispatcher": flow.Registry["Dispatcher"],
+		"FanOut":     flow.Registry["FanOut"],
+	}
+	data, _ := json.MarshalIndent(flow.Catalog(registry), "", "  ")
+	fmt.Println(string(data))
+	// Output:
+	// [
+	//   {
+	//     "name": "Dispatcher",
+	//     "help": "Send messages to gadgets created from \u003cdispatch\u003e tags.",
+	//     "circuit": true,
+	//     "inputs": [
+	//       {
+	//         "name": "In"
+	//       },
+	//       {
+	//         "name": "Prefix",
+	//         "optional": true
+	//       }
+	//     ],
+	//     "outputs": [
+	//       {
+	//         "name": "Out"
+	//       },
+	//       {
+	//         "name": "Rej"
+	//       }
+	//     ],
+	//     "labels": {
+	//       "In": "head.In",
+	//       "Out": "tail.Out",
+	//       "Prefix": "head.Prefix",
+	//       "Rej": "head.Rej"
+	//     }
+	//   },
+	//   {
+	//     "name": "FanOut",
+	//     "help": "Send each message out to all outputs in the Out map.",
+	//     "inputs": [
+	//       {
+	//         "name": "In"
+	//       }
+	//     ],
+	//     "outputs": [
+	//       {
+	//         "name": "Out",
+	//         "map": true
+	//       }
+	//     ]
+	//   }
+	// ]
+}
+
+func ExampleCatalog_badDefinition() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	defs := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(defs, []byte(`{
+		"CatalogGood": {
+			"gadgets": [{ "name": "p", "type": "Pipe" }],
+			"labels": [{ "external": "In", "internal": "p.In" }]
+		},
+		"CatalogBad": {
+			"gadgets": [{ "name": "p", "type": "Pipe" }],
+			"feeds": [{ "data": "${NOPE}", "to": "p.In" }]
+		}
+	}`), 0666)
+	flow.AddToRegistry(defs)
+
+	for _, e := range flow.Catalog(flow.Registry) {
+		if e.Name == "CatalogGood" || e.Name == "CatalogBad" {
+			fmt.Println(e.Name, e.Circuit, e.Inputs, e.Labels, e.Error)
+		}
+	}
+	// Output:
+	// CatalogBad false [] map[] undefined variable: NOPE
+	// CatalogGood true [{In false false}] map[In:p.In]
+}
@@ -10,6 +10,7 @@ import (
 // Initialise a new circuit.
 func NewCircuit() *Circuit {
 	return &Circuit{
+		done:    make(chan struct{}),
 		gadgets: map[string]*Gadget{},
 		feeds:   map[string][]Message{},
 		labels:  map[string]string{},
@@ -20,19 +21,28 @@ func NewCircuit() *Circuit {
 type Circuit struct {
 	Gadget
 
-	gnames  []gadgetDef          // gadgets added by name from the registry
-	gadgets map[string]*Gadget   // gadgets added to this circuit
-	wires   []wireDef            // list of all connections
-	feeds   map[string][]Message // message feeds
-	labels  map[string]string    // pin label lookup map
-
+	gnames  []gadgetDef            // gadgets added by name from the registry
+	gadgets map[string]*Gadget     // gadgets added to this circuit
+	wires   []wireDef              // list of all connections
+	feeds   map[string][]Message   // message feeds
+	labels  map[string]string      // pin label lookup map
+	remotes []remoteDef            // links to and from other processes
+	defName string                 // registry entry this circuit was created from
+	loaded  *config                // definition this circuit was built from
+	params  map[string]interface{} // parameters this circuit was built with
+
+	lock sync.RWMutex   // guards the above while the circuit is being patched
 	wait sync.WaitGroup // tracks number of running gadgets
+	done chan struct{}  // closed when the circuit is stopped
+	dead int32          // set when done is closed, accessed atomically
+	stop sync.Once      // makes sure done is only closed once
 }
 
 // definition of one named gadget
 type gadgetDef struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name   string                 `json:"name"`
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
 // definition of one connection
@@ -40,17 +50,36 @@ type wireDef struct {
 	From     string `json:"from"`
 	To       string `json:"to"`
 	Capacity int    `json:"capacity"`
+	Priority bool   `json:"priority,omitempty"`
+	Journal  string `json:"journal,omitempty"`
+	Codec    string `json:"codec,omitempty"`
 }
 
 // Add a named gadget to the circuit with a unique name.
 func (c *Circuit) Add(name, gadget string) {
-	constructor := Registry[gadget]
+	c.add(gadgetDef{Name: name, Type: gadget})
+}
+
+// add a gadget from the registry, circuits defined in JSON can be given
+// parameters to override their defaults
+func (c *Circuit) add(def gadgetDef) error {
+	constructor := Registry[def.Type]
 	if constructor == nil {
-		glog.Warningln("not found:", gadget)
-		return
+		glog.Warningln("not found:", def.Type)
+		return nil
+	}
+	if def.Params == nil {
+		c.gnames = append(c.gnames, def)
+		c.AddCircuitry(def.Name, constructor())
+		return nil
+	}
+	g, err := instantiate(def.Type, def.Params)
+	if err != nil {
+		return err
 	}
-	c.gnames = append(c.gnames, gadgetDef{name, gadget})
-	c.AddCircuitry(name, constructor())
+	c.gnames = append(c.gnames, def)
+	c.AddCircuitry(def.Name, g)
+	return nil
 }
 
 // Add a gadget or circuit to the circuit with a unique name.
@@ -59,6 +88,13 @@ func (c *Circuit) AddCircuitry(name string, g Circuitry) {
 }
 
 func (c *Circuit) gadgetOf(s string) *Gadget {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lookupGadget(s)
+}
+
+// same as gadgetOf, for use while the lock is already held
+func (c *Circuit) lookupGadget(s string) *Gadget {
 	// TODO: migth be useful for extending an existing circuit
 	// if gadgetPart(s) == "" && c.labels[s] != "" {
 	// 	s = c.labels[s] // unnamed gadgets can use the circuit's pin map
@@ -72,7 +108,7 @@ func (c *Circuit) gadgetOf(s string) *Gadget {
 
 // Connect an output pin with an input pin.
 func (c *Circuit) Connect(from, to string, capacity int) {
-	c.wires = append(c.wires, wireDef{from, to, capacity})
+	c.wires = append(c.wires, wireDef{From: from, To: to, Capacity: capacity})
 	w := c.gadgetOf(to).getInput(pinPart(to), capacity)
 	c.gadgetOf(from).setOutput(pinPart(from), w)
 }
@@ -95,11 +131,17 @@ func (c *Circuit) Run() {
 	for _, g := range c.gadgets {
 		g.launch()
 	}
+	if c.defName != "" {
+		trackLive(c, true) // reloads can only patch it from now on
+		defer trackLive(c, false)
+	}
 	c.wait.Wait()
 }
 
 // Return a description of this circuit in serialisable form.
 func (c *Circuit) Describe() interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	desc := map[string]interface{}{}
 	if len(c.gnames) > 0 {
 		desc["gadgets"] = c.gnames
@@ -140,5 +182,8 @@ func (c *Circuit) Describe() interface{} {
 	if len(c.labels) > 0 {
 		desc["labels"] = c.labels
 	}
+	if len(c.remotes) > 0 {
+		desc["remotes"] = c.remotes
+	}
 	return desc
 }
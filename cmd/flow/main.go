@@ -0,0 +1,429 @@
+// The flow command runs, inspects, checks, and converts circuit definitions.
+// Use "flow help" for a list of subcommands.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+	_ "github.com/jcw/flow/gadgets/script"
+	_ "github.com/jcw/flow/gadgets/wasm"
+)
+
+var (
+	setupFile = flag.String("s", "setup.json", "circuit definitions file")
+	pluginDir = flag.String("plugins", "", "directory with gadget plugins to load")
+)
+
+// a subcommand, which gets the arguments following its name
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"run":      {runCmd, "[-state file] [-http addr] [name] - run a circuit (default main)"},
+	"list":     {listCmd, "[-json] - list gadgets and circuits with their pins"},
+	"describe": {describeCmd, "name - show the structure of a circuit"},
+	"graph":    {graphCmd, "[-f dot|mermaid] name - render a circuit as graph"},
+	"validate": {lintCmd, "[file] - check a definitions file for problems"},
+	"lint":     {lintCmd, "[file] - same as validate"},
+	"fmt":      {fmtCmd, "[-w] [file] - print a definitions file in canonical form"},
+	"convert":  {convertCmd, "[-n name] file - convert between JSON and FBP"},
+	"schema":   {schemaCmd, "[-registry] - print a JSON Schema for definitions files"},
+	"runtime":  {runtimeCmd, "[-addr a] [-secret s] [-origin o,...] - serve the FBP network protocol"},
+}
+
+// errUsage signals that the command line was not valid
+var errUsage = errors.New("usage")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" {
+			fmt.Fprintln(os.Stderr, "flow: unknown command:", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+	err := flow.LoadPlugins(*pluginDir)
+	if err == nil {
+		err = cmd.run(flag.Args()[1:])
+	}
+	glog.Flush()
+	switch {
+	case err == errUsage:
+		fmt.Fprintln(os.Stderr, "usage: flow", name, cmd.usage)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "flow:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: flow [flags] command [args]\n\ncommands:")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// parse the flags of a subcommand, and check the number of remaining args
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) error {
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		return errUsage
+	}
+	return nil
+}
+
+// load the setup file and create one of the circuits defined in it
+func circuitOf(name string) (*flow.Circuit, error) {
+	if err := flow.AddToRegistry(*setupFile); err != nil {
+		return nil, err
+	}
+	factory, ok := flow.Registry[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in: %s", name, *setupFile)
+	}
+	c, ok := factory().(*flow.Circuit)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a circuit", name)
+	}
+	return c, nil
+}
+
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	state := fs.String("state", "", "restore from and checkpoint to this file")
+	addr := fs.String("http", "", "serve the control interface on this address")
+	if err := parseArgs(fs, args, 0, 1); err != nil {
+		return err
+	}
+	name := "main"
+	if fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	c, err := circuitOf(name)
+	if err != nil {
+		return err
+	}
+	if *state != "" {
+		if _, err := os.Stat(*state); err == nil {
+			if err := c.RestoreFile(*state); err != nil {
+				return err
+			}
+		}
+	}
+
+	// stop on interrupt or termination, a second signal exits right away
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	glog.Infof("Flow %s - starting %s, registry size %d",
+		flow.Version, name, len(flow.Registry))
+	var done <-chan struct{}
+	if *addr != "" {
+		// the server runs the circuit, keep serving until it is done
+		server := flow.NewServer()
+		done = server.Attach(name, c)
+		go func() {
+			glog.Fatal(http.ListenAndServe(*addr, server))
+		}()
+	} else {
+		finished := make(chan struct{})
+		go func() {
+			c.Run()
+			close(finished)
+		}()
+		done = finished
+	}
+
+	var stopped error
+	select {
+	case sig := <-signals:
+		glog.Infoln("stopping on signal:", sig)
+		c.Stop()
+		go func() {
+			sig := <-signals
+			glog.Errorln("exit on signal:", sig)
+			glog.Flush()
+			os.Exit(1)
+		}()
+		<-done
+		stopped = fmt.Errorf("stopped on signal: %s", sig)
+	case <-done:
+		glog.Infof("Flow %s - normal exit", flow.Version)
+	}
+
+	// only save the state once the circuit is no longer running
+	if *state != "" {
+		if err := c.CheckpointFile(*state); err != nil {
+			return err
+		}
+	}
+	return stopped
+}
+
+func listCmd(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the catalog as JSON")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	flow.AddToRegistry(*setupFile) // include its circuits, if any
+	if *asJSON {
+		flow.PrintCatalog()
+		return nil
+	}
+	for _, e := range flow.Catalog(flow.Registry) {
+		ins, outs := []string{}, []string{}
+		for _, p := range e.Inputs {
+			if p.Optional {
+				p.Name += "?"
+			}
+			ins = append(ins, p.Name)
+		}
+		for _, p := range e.Outputs {
+			if p.Map {
+				p.Name += ":*"
+			}
+			outs = append(outs, p.Name)
+		}
+		fmt.Printf("%-16s in: %-24s out: %s\n", e.Name,
+			strings.Join(ins, " "), strings.Join(outs, " "))
+		if e.Help != "" {
+			fmt.Printf("%16s %s\n", "", e.Help)
+		}
+		if e.Plugin != "" {
+			fmt.Printf("%16s (from plugin %s)\n", "", e.Plugin)
+		}
+		if e.Error != "" {
+			fmt.Printf("%16s error: %s\n", "", e.Error)
+		}
+	}
+	return nil
+}
+
+func schemaCmd(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	registry := fs.Bool("registry", false, "only allow registered types and pins")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	var s map[string]interface{}
+	if *registry {
+		flow.AddToRegistry(*setupFile) // include its circuits, if any
+		s = flow.Schema(flow.Registry)
+	} else {
+		s = flow.Schema(nil)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func describeCmd(args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := circuitOf(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c.Describe(), "", "  ")
+	if err == nil {
+		fmt.Println(string(data))
+	}
+	return err
+}
+
+func graphCmd(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("f", "dot", "output format: dot or mermaid")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := circuitOf(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "dot":
+		return c.WriteDot(os.Stdout)
+	case "mermaid":
+		return c.WriteMermaid(os.Stdout)
+	}
+	return fmt.Errorf("unknown graph format: %s", *format)
+}
+
+func lintCmd(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 0, 1); err != nil {
+		return err
+	}
+	filename := *setupFile
+	if fs.NArg() > 0 {
+		filename = fs.Arg(0)
+	}
+	issues, err := flow.Lint(flow.Registry, filename)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if issue.Severity == flow.LintError {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s: %d error(s)", filename, failed)
+	}
+	return nil
+}
+
+func fmtCmd(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the result back to the file")
+	if err := parseArgs(fs, args, 0, 1); err != nil {
+		return err
+	}
+	filename := *setupFile
+	if fs.NArg() > 0 {
+		filename = fs.Arg(0)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	out, err := canonicalJSON(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if *write {
+		if bytes.Equal(data, out) {
+			return nil
+		}
+		return ioutil.WriteFile(filename, out, 0666)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// re-encode JSON with sorted keys and a fixed indentation, keeping all numbers
+// exactly as written
+func canonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func convertCmd(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	name := fs.String("n", "main", "circuit name, in the JSON definitions")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	filename := fs.Arg(0)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if strings.ToLower(filepath.Ext(filename)) == ".fbp" {
+		c := flow.NewCircuit()
+		if err := c.LoadFBP(data); err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+		var buf bytes.Buffer
+		if err := c.WriteJSON(&buf); err != nil {
+			return err
+		}
+		out, err := canonicalJSON([]byte(fmt.Sprintf("{%q: %s}", *name,
+			buf.Bytes())))
+		if err == nil {
+			_, err = os.Stdout.Write(out)
+		}
+		return err
+	}
+
+	*setupFile = filename
+	c, err := circuitOf(*name)
+	if err != nil {
+		return err
+	}
+	return c.WriteFBP(os.Stdout)
+}
+
+func runtimeCmd(args []string) error {
+	fs := flag.NewFlagSet("runtime", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:3569", "address to listen on")
+	secret := fs.String("secret", "", "secret clients must send (default random)")
+	origin := fs.String("origin", "", "comma-separated web origins allowed to connect")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	flow.AddToRegistry(*setupFile) // include its circuits, if any
+	rt := flow.NewFBPRuntime()
+	rt.Secret = *secret
+	if rt.Secret == "" {
+		var nonce [16]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return err
+		}
+		rt.Secret = hex.EncodeToString(nonce[:])
+		fmt.Fprintln(os.Stderr, "FBP runtime secret:", rt.Secret)
+	}
+	if *origin != "" {
+		rt.Origins = strings.Split(*origin, ",")
+	}
+	glog.Infoln("FBP runtime listening on:", *addr)
+	return http.ListenAndServe(*addr, rt)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func Example_fmt() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	setup := filepath.Join(dir, "setup.json")
+	ioutil.WriteFile(setup, []byte(`{"main": {"gadgets":
+		[{"type": "Printer", "name": "p"}], "feeds": [{"to": "p.In",
+		"data": 1.50}]}}`), 0666)
+	fmtCmd([]string{setup})
+	// Output:
+	// {
+	//   "main": {
+	//     "feeds": [
+	//       {
+	//         "data": 1.50,
+	//         "to": "p.In"
+	//       }
+	//     ],
+	//     "gadgets": [
+	//       {
+	//         "name": "p",
+	//         "type": "Printer"
+	//       }
+	//     ]
+	//   }
+	// }
+}
+
+func Example_convert() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	fbp := filepath.Join(dir, "hello.fbp")
+	ioutil.WriteFile(fbp, []byte(`'hello' -> IN p(Pipe) OUT -> IN q(Printer)`),
+		0666)
+	convertCmd([]string{"-n", "hello", fbp})
+
+	setup := filepath.Join(dir, "setup.json")
+	ioutil.WriteFile(setup, []byte(`{"hello": {
+		"gadgets": [
+			{"name": "p", "type": "Pipe"},
+			{"name": "q", "type": "Printer"}
+		],
+		"wires": [{"from": "p.Out", "to": "q.In"}],
+		"feeds": [{"data": "hello", "to": "p.In"}]
+	}}`), 0666)
+	convertCmd([]string{"-n", "hello", setup})
+	// Output:
+	// {
+	//   "hello": {
+	//     "feeds": [
+	//       {
+	//         "data": "hello",
+	//         "to": "p.In"
+	//       }
+	//     ],
+	//     "gadgets": [
+	//       {
+	//         "name": "p",
+	//         "type": "Pipe"
+	//       },
+	//       {
+	//         "name": "q",
+	//         "type": "Printer"
+	//       }
+	//     ],
+	//     "wires": [
+	//       {
+	//         "capacity": 0,
+	//         "from": "p.Out",
+	//         "to": "q.In"
+	//       }
+	//     ]
+	//   }
+	// }
+	// 'hello' -> IN p(Pipe)
+	// p OUT -> IN q(Printer)
+}
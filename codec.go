@@ -0,0 +1,226 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/glog"
+)
+
+// A codec converts messages to bytes and back, so that they can be stored or
+// sent outside this process.
+type Codec interface {
+	Encode(m Message) ([]byte, error)
+	Decode(data []byte) (Message, error)
+}
+
+// Codecs lists all known message codecs by name.
+var Codecs = map[string]Codec{
+	"json":    jsonCodec{},
+	"gob":     gobCodec{},
+	"msgpack": msgpackCodec{},
+}
+
+// look up a codec by name, the empty string selects the JSON codec
+func codecOf(name string) Codec {
+	codec, err := LookupCodec(name)
+	if err != nil {
+		glog.Fatalln(err)
+	}
+	return codec
+}
+
+// LookupCodec returns the codec with the given name, or the JSON codec if the
+// name is empty.
+func LookupCodec(name string) (Codec, error) {
+	if name == "" {
+		name = "json"
+	}
+	codec := Codecs[name]
+	if codec == nil {
+		return nil, fmt.Errorf("codec not found: %s", name)
+	}
+	return codec, nil
+}
+
+var (
+	typesByName = map[string]reflect.Type{}
+	typeNames   = map[reflect.Type]string{}
+)
+
+func init() {
+	RegisterType("Tag", Tag{})
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// RegisterType makes a message type known to all codecs under the given name,
+// so that messages of this type are decoded with the same Go type again. The
+// sample is any value of that type, use a pointer for pointer messages. The
+// names "Tag" and "Map" are used by the JSON codec itself.
+func RegisterType(name string, sample interface{}) {
+	t := reflect.TypeOf(sample)
+	typesByName[name] = t
+	typeNames[t] = name
+	gob.RegisterName(name, sample)
+}
+
+// convert a generic decoded value back to a registered type, going through
+// JSON so that JSON field names and tags apply to all codecs alike
+func typedValue(name string, v interface{}) (Message, error) {
+	t, ok := typesByName[name]
+	if !ok {
+		return nil, fmt.Errorf("type not registered: %s", name)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	target := t
+	if t.Kind() == reflect.Ptr {
+		target = t.Elem()
+	}
+	p := reflect.New(target)
+	if err := json.Unmarshal(data, p.Interface()); err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Ptr {
+		return p.Interface(), nil
+	}
+	return p.Elem().Interface(), nil
+}
+
+// convert a registered type to a generic value, as JSON would decode it
+func genericValue(m Message) (interface{}, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// The JSON codec is readable and interoperable, but it only preserves the Go
+// types of tags and registered types, which are wrapped as {"$type": name,
+// "$value": value}. Maps which have a "$type" key of their own are wrapped as
+// well, with "Map" as type, so that they are not mistaken for such a wrapper.
+// All other numbers are decoded as float64, and all other structs as maps.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(m Message) ([]byte, error) {
+	v, err := wrapTypes(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte) (Message, error) {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return unwrapTypes(m)
+}
+
+// replace tags and registered types by their JSON envelopes
+func wrapTypes(m Message) (interface{}, error) {
+	switch v := m.(type) {
+	case Tag:
+		msg, err := wrapTypes(v.Msg)
+		return map[string]interface{}{
+			"$type":  "Tag",
+			"$value": map[string]interface{}{"Tag": v.Tag, "Msg": msg},
+		}, err
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = wrapTypes(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var err error
+			if obj[k], err = wrapTypes(item); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := v["$type"]; ok {
+			return map[string]interface{}{"$type": "Map", "$value": obj}, nil
+		}
+		return obj, nil
+	}
+	if name, ok := typeNames[reflect.TypeOf(m)]; ok {
+		return map[string]interface{}{"$type": name, "$value": m}, nil
+	}
+	return m, nil
+}
+
+// turn JSON envelopes back into tags and registered types
+func unwrapTypes(v interface{}) (Message, error) {
+	var err error
+	switch x := v.(type) {
+	case []interface{}:
+		for i := range x {
+			if x[i], err = unwrapTypes(x[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		name, ok := x["$type"].(string)
+		if ok && len(x) == 2 {
+			inner, _ := x["$value"].(map[string]interface{})
+			switch name {
+			case "Tag":
+				tag, _ := inner["Tag"].(string)
+				msg, err := unwrapTypes(inner["Msg"])
+				return Tag{tag, msg}, err
+			case "Map": // only its values can be wrappers
+				for k := range inner {
+					if inner[k], err = unwrapTypes(inner[k]); err != nil {
+						return nil, err
+					}
+				}
+				return inner, nil
+			}
+			return typedValue(name, x["$value"])
+		}
+		for k := range x {
+			if x[k], err = unwrapTypes(x[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// The gob codec preserves all Go types, as long as the types of all values
+// stored in a Message, including those nested in maps and slices, have been
+// registered with RegisterType or gob.Register. Most built-in types are
+// registered by default.
+type gobCodec struct{}
+
+// gob needs a concrete type to encode interface values in
+type gobEnvelope struct {
+	M Message
+}
+
+func (gobCodec) Encode(m Message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(gobEnvelope{m})
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Decode(data []byte) (Message, error) {
+	var env gobEnvelope
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&env)
+	return env.M, err
+}
@@ -0,0 +1,53 @@
+package flow_test
+
+import (
+	"fmt"
+
+	"github.com/jcw/flow"
+)
+
+type reading struct {
+	Node string
+	Temp float64
+}
+
+func init() {
+	flow.RegisterType("reading", reading{})
+}
+
+func ExampleRegisterType() {
+	m := flow.Tag{"rf12", []interface{}{reading{"n1", 21.5}, flow.Tag{"id", 3}}}
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		codec, _ := flow.LookupCodec(name)
+		data, err := codec.Encode(m)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		back, err := codec.Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		tag := back.(flow.Tag)
+		list := tag.Msg.([]interface{})
+		fmt.Printf("%s: %s %T %T %v\n", name, tag.Tag, list[0], list[1], list)
+	}
+	// Output:
+	// json: rf12 flow_test.reading flow.Tag [{n1 21.5} {id 3}]
+	// gob: rf12 flow_test.reading flow.Tag [{n1 21.5} {id 3}]
+	// msgpack: rf12 flow_test.reading flow.Tag [{n1 21.5} {id 3}]
+}
+
+func ExampleLookupCodec_typeKey() {
+	// a map which looks like a wrapped type comes back as the same map
+	m := map[string]interface{}{"$type": "reading", "$value": flow.Tag{"a", 1}}
+	codec, _ := flow.LookupCodec("json")
+	data, _ := codec.Encode(m)
+	fmt.Println(string(data))
+	back, err := codec.Decode(data)
+	fmt.Printf("%v %v\n", back, err)
+	// Output:
+	// {"$type":"Map","$value":{"$type":"reading","$value":{"$type":"Tag","$value":{"Msg":1,"Tag":"a"}}}}
+	// map[$type:reading $value:{a 1}] <nil>
+}
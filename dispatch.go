@@ -17,6 +17,7 @@ func init() {
 		c.Label("Out", "tail.Out")
 		return c
 	}
+	Help["Dispatcher"] = "Send messages to gadgets created from <dispatch> tags."
 }
 
 // A dispatcher sends messages to newly created gadgets, based on dispatch tags.
@@ -31,7 +32,7 @@ type Dispatcher Circuit
 type dispatchHead struct {
 	Gadget
 	In     Input
-	Prefix Input
+	Prefix Input `flow:"optional"`
 	Reply  Input
 	Feeds  map[string]Output
 	Rej    Output
@@ -97,6 +97,74 @@ To make a gadget available by name in the registry, set up a factory method:
     ...
     g.Add("ll", "LineLen")
 
+Wires normally deliver messages in FIFO order. Wires set up with
+ConnectPriority let messages wrapped in a Priority overtake queued ones:
+
+    g.ConnectPriority("r.Out", "c.In", 100)
+    ...
+    w.Out.Send(flow.Priority{1, "urgent"})
+
+Messages wrapped in a Control bypass the input pins altogether, and end up on
+the gadget's Control channel. Gadgets can use Next to pick these up before any
+regular input, without having to select over several channels themselves.
+
+Wires set up with ConnectDurable journal each message to a file until it has
+been taken by the receiving gadget. Anything left over is replayed on the next
+start. In JSON, add a "journal" path (and optionally a "codec") to the wire:
+
+    { "from": "r.Out", "to": "c.In", "journal": "data/r-c.log" }
+
+The codecs in Codecs ("json", "gob", and "msgpack") are shared by journals,
+remote links, and the WriteFileMessages and ReadFileMessages gadgets. Message
+types registered with RegisterType, as well as tags, come back as the same Go
+type, also when nested inside tags, slices, or maps.
+
+Long-running applications can pick up changes to their definitions file with
+WatchRegistry. Running circuits are then patched in place: only gadgets whose
+type, feeds, or wires have changed are replaced, all others keep their state.
+
+Linear pipelines can also be built in Go with type-checked stages, starting
+from Source and adding Map, Filter, Batch, and Sink stages. This generates an
+ordinary circuit, with one gadget per stage.
+
+Gadgets can also be added without recompiling, as Go plugins which register
+them from their init functions. LoadPlugins loads all plugins in a directory,
+and checks that they were built against the same version of this package.
+
+Small transformations can be written in JavaScript with the Script gadget,
+from the separate gadgets/script package. Its source is set on the Src pin,
+so that circuits defined in JSON can include their own custom logic. The
+Filter, Map, and Switch gadgets in the same package take a single expression,
+such as "msg.temp > 20", which is compiled before any messages are processed.
+
+Untrusted logic can run sandboxed in the Wasm gadget, from the gadgets/wasm
+package, which passes each message to a WebAssembly module. Each module gets
+limited memory, and limited time for each message.
+
+Gadgets can also be written in other languages, as programs which read JSON
+messages from standard input and write them to standard output, one per line.
+The Process gadget runs such a program, and restarts it if it fails. A
+definitions file can register one under its own name, with a "process" entry:
+
+    "Decode": { "process": { "command": ["python3", "decode.py"] } }
+
+Circuits can be split across processes with SendRemote and ReceiveRemote, or
+with "remotes" entries in a definition, which link an output pin to an input
+pin in another process over TCP or Unix domain sockets.
+
+A Server provides an HTTP interface to inspect running circuits, with queue
+depths and message counts of all wires, to inject and tap messages, and to
+start and stop circuits from the registry. Traffic on selected wires can be
+watched live as a stream of server-sent events. An FBPRuntime serves the FBP
+network protocol over WebSocket, so that circuits can be edited and run from
+FBP tools such as Flowhub.
+
+Circuit definitions can declare parameters with defaults in a "params" object,
+and refer to them as ${name} in gadget types and feed data. A gadget entry in
+another circuit then supplies its own values, e.g.:
+
+    { "name": "t", "type": "Poller", "params": { "rate": "5s" } }
+
 Message is a synonym for Go's generic "interface{}" type.
 */
 package flow
@@ -0,0 +1,235 @@
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Connect an output pin with an input pin, using a wire which journals every
+// message to a file until the receiving gadget has taken it. Messages which
+// were not taken are replayed into the circuit when it is started again.
+func (c *Circuit) ConnectDurable(from, to string, capacity int, path, codec string) {
+	c.wires = append(c.wires, wireDef{From: from, To: to, Capacity: capacity,
+		Journal: path, Codec: codec})
+	w := c.gadgetOf(to).getInput(pinPart(to), capacity)
+	w.journal = &journal{path: path, codec: codecOf(codec)}
+	c.gadgetOf(from).setOutput(pinPart(from), w)
+}
+
+// The journal is an append-only file with message and acknowledge records.
+type journal struct {
+	path  string
+	codec Codec
+	file  *os.File
+	seq   uint64
+}
+
+// one queued message, the sequence number is zero if it's not in the journal
+type journalEntry struct {
+	seq uint64
+	msg Message
+}
+
+const (
+	journalMessage = 'M'
+	journalAck     = 'A'
+	journalFormat  = 'F' // the format version as seq, first in the file
+)
+
+// Format of the messages written to journals. In version 1, the JSON codec
+// wraps tags and registered types in envelopes. Journals without a format
+// record are from before then, and contain plain JSON.
+const journalVersion = 1
+
+// Largest record read from a journal or a remote link. The size of a record
+// comes before its data, a corrupt size would otherwise be allocated as is.
+const maxJournalRecord = 16 << 20
+
+// open the journal, and return all messages which were never acknowledged
+func (j *journal) open() []journalEntry {
+	pending := map[uint64][]byte{}
+	order := []uint64{}
+	version := uint64(journalVersion) // for new journals
+	if f, err := os.Open(j.path); err == nil {
+		version = 0
+		r := bufio.NewReader(f)
+		for {
+			kind, seq, data, err := readJournalRecord(r)
+			if err != nil {
+				break // a truncated last record is silently dropped
+			}
+			switch kind {
+			case journalFormat:
+				version = seq
+				continue
+			case journalMessage:
+				pending[seq] = data
+				order = append(order, seq)
+			case journalAck:
+				delete(pending, seq)
+			}
+			if seq > j.seq {
+				j.seq = seq
+			}
+		}
+		f.Close()
+	}
+
+	// compact the journal by rewriting it with only the pending messages
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	Check(err)
+	Check(writeJournalRecord(f, journalFormat, journalVersion, nil))
+	decode := j.codec.Decode
+	if _, ok := j.codec.(jsonCodec); ok && version == 0 {
+		decode = decodePlainJSON
+	}
+	entries := []journalEntry{}
+	for _, seq := range order {
+		if data, ok := pending[seq]; ok {
+			m, err := decode(data)
+			Check(err)
+			if version != journalVersion {
+				data, err = j.codec.Encode(m) // convert to the current format
+				Check(err)
+			}
+			Check(writeJournalRecord(f, journalMessage, seq, data))
+			entries = append(entries, journalEntry{seq, m})
+		}
+	}
+	Check(f.Close())
+	Check(os.Rename(tmp, j.path))
+
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0666)
+	Check(err)
+	return entries
+}
+
+// decode JSON as it was written before the JSON codec used envelopes
+func decodePlainJSON(data []byte) (Message, error) {
+	var m Message
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+// add a message to the journal and return its entry
+func (j *journal) append(m Message) journalEntry {
+	data, err := j.codec.Encode(m)
+	Check(err)
+	j.seq++
+	Check(writeJournalRecord(j.file, journalMessage, j.seq, data))
+	return journalEntry{j.seq, m}
+}
+
+// mark a message as taken, so it won't be replayed
+func (j *journal) ack(e journalEntry) {
+	if e.seq != 0 {
+		Check(writeJournalRecord(j.file, journalAck, e.seq, nil))
+	}
+}
+
+func (j *journal) close() {
+	Check(j.file.Close())
+}
+
+// each record is a kind byte, followed by the sequence number and data length
+// as varints, followed by the data itself
+func writeJournalRecord(w io.Writer, kind byte, seq uint64, data []byte) error {
+	buf := make([]byte, 1+2*binary.MaxVarintLen64+len(data))
+	buf[0] = kind
+	n := 1 + binary.PutUvarint(buf[1:], seq)
+	n += binary.PutUvarint(buf[n:], uint64(len(data)))
+	n += copy(buf[n:], data)
+	_, err := w.Write(buf[:n]) // single write, no partial records on exit
+	return err
+}
+
+func readJournalRecord(r *bufio.Reader) (byte, uint64, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	seq, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if size > maxJournalRecord {
+		return 0, 0, nil, fmt.Errorf("record too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return kind, seq, data, err
+}
+
+// set up a pump which journals all incoming messages and acknowledges them
+// once the gadget has received them, feeds are delivered but not journaled,
+// the wire's channel is only replaced once the journal has been opened, once
+// quit is closed, incoming messages are still journaled but not delivered
+func (c *wire) persist(feeds []Message, quit chan struct{}) chan Message {
+	queue := c.journal.open()
+	for _, msg := range feeds {
+		if m, ok := c.filter(msg); ok {
+			queue = append(queue, journalEntry{0, m})
+		}
+	}
+	limit := c.capacity
+	if limit < 1 {
+		limit = 1
+	}
+	inbox := make(chan Message)
+	c.mutex.Lock()
+	c.channel, c.closed, c.quit = inbox, false, quit
+	if c.senders == 0 {
+		c.closeChannel()
+	}
+	c.publish()
+	c.mutex.Unlock()
+
+	out := make(chan Message)
+	owner := c.dest.owner
+	owner.wait.Add(1) // the journal must be closed before Run returns
+	stopped := owner.done
+	go func() {
+		defer owner.wait.Done()
+		for inbox != nil || (quit != nil && len(queue) > 0) {
+			atomic.StoreInt64(&c.backlog, int64(len(queue)))
+			recv := inbox
+			if quit != nil && len(queue) >= limit {
+				recv = nil // full, only deliver
+			}
+			var send chan Message
+			var next Message
+			if quit != nil && len(queue) > 0 {
+				send, next = out, queue[0].msg
+			}
+			select {
+			case m, ok := <-recv:
+				if ok {
+					queue = append(queue, c.journal.append(m))
+				} else {
+					inbox = nil
+				}
+			case send <- next:
+				c.journal.ack(queue[0])
+				queue = queue[1:]
+			case <-quit:
+				quit = nil // the gadget is gone, the rest stays in the journal
+			case <-stopped:
+				quit, stopped = nil, nil // no more deliveries, as with quit
+			}
+		}
+		atomic.StoreInt64(&c.backlog, int64(len(queue))) // left in the journal
+		c.journal.close()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,88 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+// a gadget which sends some messages, and then closes its release channel
+type burst struct {
+	flow.Gadget
+	Out flow.Output
+
+	release chan struct{}
+}
+
+func (g *burst) Run() {
+	for _, s := range []string{"abc", "def", "ghi"} {
+		g.Out.Send(s)
+	}
+	close(g.release)
+}
+
+// a gadget which takes one message, and then quits as if the process died
+type stall struct {
+	flow.Gadget
+	In flow.Input
+
+	release chan struct{}
+}
+
+func (g *stall) Run() {
+	fmt.Println("took:", <-g.In)
+	<-g.release
+}
+
+func ExampleCircuit_ConnectDurable() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "wire.log")
+
+	// everything is journaled before s quits, Run returns once it's closed
+	release := make(chan struct{})
+	g1 := flow.NewCircuit()
+	g1.AddCircuitry("b", &burst{release: release})
+	g1.AddCircuitry("s", &stall{release: release})
+	g1.ConnectDurable("b.Out", "s.In", 10, journal, "json")
+	g1.Run()
+
+	// the same wire in a new circuit gets the messages which weren't taken
+	g2 := flow.NewCircuit()
+	g2.Add("p", "Pipe")
+	g2.Add("c", "Printer")
+	g2.ConnectDurable("p.Out", "c.In", 10, journal, "json")
+	g2.Run()
+	// Output:
+	// took: abc
+	// def
+	// ghi
+}
+
+func ExampleCircuit_ConnectDurable_oldJournal() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "wire.log")
+
+	// a journal written before the format record, with plain JSON messages
+	old := `{"$type":"Tag","$value":1}`
+	record := append([]byte{'M', 1, byte(len(old))}, old...)
+	ioutil.WriteFile(journal, record, 0666)
+
+	for i := 0; i < 2; i++ {
+		g := flow.NewCircuit()
+		g.Add("p", "Pipe")
+		g.Add("c", "Printer")
+		g.ConnectDurable("p.Out", "c.In", 10, journal, "json")
+		g.Feed("p.In", flow.Tag{"run", i})
+		g.Run()
+	}
+	// Output:
+	// map[$type:Tag $value:1]
+	// {Tag:run Msg:0}
+	// {Tag:run Msg:1}
+}
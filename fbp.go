@@ -0,0 +1,412 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// LoadFBP sets up a circuit from a description in the FBP notation used by
+// NoFlo and goflow, for example:
+//
+//	'3' -> NUM r(Repeater) OUT -> IN c(Counter)
+//	INPORT=r.IN:In
+//	OUTPORT=c.OUT:Out
+//
+// Initial packets become feeds, with numbers and other JSON values decoded.
+// Port names are matched to pins without regard to case, and "OUT[x]" refers
+// to pin "x" of the "Out" output map. INPORT and OUTPORT become labels.
+// Config settings are expanded as in LoadJSON, before parsing the text.
+func (c *Circuit) LoadFBP(data []byte) error {
+	text, err := interpolate(string(data), nil)
+	if err != nil {
+		return err
+	}
+	conf, err := parseFBP([]byte(text))
+	if err == nil {
+		err = conf.validate(nil)
+	}
+	if err == nil {
+		err = c.build(conf)
+	}
+	return err
+}
+
+// one connection, before the port names have been resolved
+type fbpLink struct {
+	line     int
+	iip      *string
+	from, to string // "proc.PORT"
+}
+
+// one exported port, before the port name has been resolved
+type fbpPort struct {
+	line     int
+	internal string // "proc.PORT"
+	external string
+}
+
+func parseFBP(data []byte) (*config, error) {
+	conf := &config{}
+	procs := map[string]string{}
+	links := []fbpLink{}
+	ports := []fbpPort{}
+
+	// a process reference may be followed by "(Type)" on first use
+	declare := func(line int, ref string) (string, error) {
+		name, typ := ref, ""
+		if n := strings.IndexByte(ref, '('); n >= 0 {
+			if !strings.HasSuffix(ref, ")") {
+				return "", fmt.Errorf("line %d: bad process: %s", line, ref)
+			}
+			name, typ = ref[:n], ref[n+1:len(ref)-1]
+			if n := strings.IndexByte(typ, ':'); n >= 0 {
+				typ = typ[:n] // drop metadata
+			}
+		}
+		if name == "" || strings.ContainsAny(name, ".[]") {
+			return "", fmt.Errorf("line %d: bad process name: %s", line, ref)
+		}
+		if typ != "" {
+			if prev, ok := procs[name]; ok && prev != "" && prev != typ {
+				return "", fmt.Errorf("line %d: %s redeclared as %s", line,
+					name, typ)
+			}
+			if procs[name] == "" {
+				conf.Gadgets = append(conf.Gadgets, gadgetDef{Name: name, Type: typ})
+			}
+			procs[name] = typ
+		} else if _, ok := procs[name]; !ok {
+			procs[name] = ""
+		}
+		return name, nil
+	}
+
+	for i, text := range strings.Split(string(data), "\n") {
+		line := i + 1
+		statements, err := splitFBP(line, text)
+		if err != nil {
+			return nil, err
+		}
+		for _, tokens := range statements {
+			first := tokens[0]
+			if strings.HasPrefix(first, "INPORT=") ||
+				strings.HasPrefix(first, "OUTPORT=") {
+				if len(tokens) != 1 {
+					return nil, fmt.Errorf("line %d: bad export", line)
+				}
+				spec := first[strings.IndexByte(first, '=')+1:]
+				n := strings.LastIndex(spec, ":")
+				if n < 0 || !strings.Contains(spec[:n], ".") {
+					return nil, fmt.Errorf("line %d: bad export: %s", line, spec)
+				}
+				ports = append(ports, fbpPort{line, spec[:n], spec[n+1:]})
+				continue
+			}
+
+			// split the chain into segments between arrows
+			segs := [][]string{{}}
+			for _, t := range tokens {
+				if t == "->" {
+					segs = append(segs, []string{})
+				} else {
+					segs[len(segs)-1] = append(segs[len(segs)-1], t)
+				}
+			}
+			if len(segs) == 1 {
+				if len(tokens) != 1 || tokens[0][0] == '\'' {
+					return nil, fmt.Errorf("line %d: arrow expected", line)
+				}
+				if _, err := declare(line, tokens[0]); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			var link fbpLink
+			for n, seg := range segs {
+				last := n == len(segs)-1
+				switch {
+				case n == 0 && len(seg) == 1 && seg[0][0] == '\'':
+					iip := seg[0][1:]
+					link = fbpLink{line: line, iip: &iip}
+					continue
+				case n == 0 && len(seg) == 2:
+					proc, err := declare(line, seg[0])
+					if err != nil {
+						return nil, err
+					}
+					link = fbpLink{line: line, from: proc + "." + seg[1]}
+					continue
+				case n > 0 && !last && len(seg) == 3, last && len(seg) == 2:
+					proc, err := declare(line, seg[1])
+					if err != nil {
+						return nil, err
+					}
+					link.to = proc + "." + seg[0]
+					links = append(links, link)
+					if !last {
+						link = fbpLink{line: line, from: proc + "." + seg[2]}
+					}
+					continue
+				}
+				return nil, fmt.Errorf("line %d: cannot parse: %s", line,
+					strings.Join(seg, " "))
+			}
+		}
+	}
+
+	// now that all processes are known, look up the actual pin names
+	instances := map[string]Circuitry{}
+	resolve := func(line int, ref string) (string, error) {
+		proc, port := gadgetPart(ref), pinPart(ref)
+		typ := procs[proc]
+		if typ == "" {
+			return "", fmt.Errorf("line %d: no type for process: %s", line, proc)
+		}
+		if Registry[typ] == nil {
+			return "", fmt.Errorf("line %d: unknown gadget type: %s", line, typ)
+		}
+		if instances[proc] == nil {
+			instances[proc] = Registry[typ]()
+		}
+		index := ""
+		if n := strings.IndexByte(port, '['); n >= 0 && strings.HasSuffix(port, "]") {
+			port, index = port[:n], ":"+port[n+1:len(port)-1]
+		}
+		for _, pin := range pinNames(instances[proc]) {
+			if strings.EqualFold(pin, port) {
+				return proc + "." + pin + index, nil
+			}
+		}
+		return "", fmt.Errorf("line %d: pin not found: %s", line, ref)
+	}
+
+	for _, l := range links {
+		to, err := resolve(l.line, l.to)
+		if err != nil {
+			return nil, err
+		}
+		if l.iip != nil {
+			conf.Feeds = append(conf.Feeds, feedDef{Data: decodeIIP(*l.iip), To: to})
+			continue
+		}
+		from, err := resolve(l.line, l.from)
+		if err != nil {
+			return nil, err
+		}
+		conf.Wires = append(conf.Wires, wireDef{From: from, To: to})
+	}
+	for _, p := range ports {
+		internal, err := resolve(p.line, p.internal)
+		if err != nil {
+			return nil, err
+		}
+		conf.Labels = append(conf.Labels, labelDef{p.external, internal})
+	}
+	return conf, nil
+}
+
+// split one line into statements, each a list of tokens, quoted IIPs are
+// returned unquoted, with a leading quote character to tell them apart
+func splitFBP(line int, text string) ([][]string, error) {
+	statements := [][]string{}
+	tokens := []string{}
+	word := ""
+	flush := func() {
+		if word != "" {
+			tokens = append(tokens, word)
+			word = ""
+		}
+	}
+	end := func() {
+		flush()
+		if len(tokens) > 0 {
+			statements = append(statements, tokens)
+			tokens = []string{}
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '#':
+			i = len(text)
+		case ch == ',':
+			end()
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			flush()
+		case ch == '-' && i+1 < len(text) && text[i+1] == '>':
+			flush()
+			tokens = append(tokens, "->")
+			i++
+		case ch == '\'':
+			flush()
+			var buf bytes.Buffer
+			buf.WriteByte('\'')
+			for i++; i < len(text) && text[i] != '\''; i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				buf.WriteByte(text[i])
+			}
+			if i >= len(text) {
+				return nil, fmt.Errorf("line %d: unterminated quote", line)
+			}
+			tokens = append(tokens, buf.String())
+		default:
+			word += string(ch)
+		}
+	}
+	end()
+	return statements, nil
+}
+
+// IIPs which are valid JSON are decoded, using ints for integral numbers
+func decodeIIP(s string) Message {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil || dec.More() {
+		return s
+	}
+	return fromJSONNumbers(v)
+}
+
+func fromJSONNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return int(n)
+		}
+		f, _ := x.Float64()
+		return f
+	case []interface{}:
+		for i, e := range x {
+			x[i] = fromJSONNumbers(e)
+		}
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
+
+// list the names of all pins of a gadget or circuit
+func pinNames(cy Circuitry) []string {
+	return sortedKeys(pinsOf(cy))
+}
+
+// WriteFBP renders the circuit in FBP notation. Details which cannot be
+// expressed in this notation, such as tags, capacities, and gadgets which
+// were not added from the registry, are written out as comments.
+func (c *Circuit) WriteFBP(w io.Writer) error {
+	var buf bytes.Buffer
+	types := map[string]string{}
+	for _, g := range c.gnames {
+		types[g.Name] = g.Type
+	}
+	declared := map[string]bool{}
+	proc := func(name string) string {
+		if declared[name] || types[name] == "" {
+			return name
+		}
+		declared[name] = true
+		return name + "(" + types[name] + ")"
+	}
+	port := func(pin string) string {
+		p := strings.SplitN(pin, ":", 2)
+		if len(p) > 1 {
+			return strings.ToUpper(p[0]) + "[" + p[1] + "]"
+		}
+		return strings.ToUpper(pin)
+	}
+
+	for _, k := range sortedKeys(c.gadgets) {
+		if types[k] == "" {
+			fmt.Fprintf(&buf, "# %s is not from the registry\n", k)
+		}
+	}
+	for _, pin := range sortedKeys(c.feeds) {
+		for _, m := range c.feeds[pin] {
+			if t, ok := m.(Tag); ok {
+				fmt.Fprintf(&buf, "# tag %q not supported, feeding data only\n",
+					t.Tag)
+				m = t.Msg
+			}
+			fmt.Fprintf(&buf, "%s -> %s %s\n", encodeIIP(m),
+				port(pinPart(pin)), proc(gadgetPart(pin)))
+		}
+	}
+	for _, wd := range c.wires {
+		fmt.Fprintf(&buf, "%s %s -> %s %s", proc(gadgetPart(wd.From)),
+			port(pinPart(wd.From)), port(pinPart(wd.To)), proc(gadgetPart(wd.To)))
+		if wd.Capacity != 0 {
+			fmt.Fprintf(&buf, " # capacity %d", wd.Capacity)
+		}
+		buf.WriteByte('\n')
+	}
+	for _, g := range c.gnames {
+		if !declared[g.Name] {
+			fmt.Fprintln(&buf, proc(g.Name))
+		}
+	}
+	for _, ext := range sortedKeys(c.labels) {
+		internal := c.labels[ext]
+		kind := "INPORT"
+		if pinIsOutput(c.gadgets[gadgetPart(internal)], pinPart(internal)) {
+			kind = "OUTPORT"
+		}
+		fmt.Fprintf(&buf, "%s=%s.%s:%s\n", kind, gadgetPart(internal),
+			port(pinPart(internal)), ext)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// quote a message as IIP, strings are only JSON-encoded if they'd be decoded
+func encodeIIP(m Message) string {
+	s, ok := m.(string)
+	if !ok || decodeIIP(s) != Message(s) {
+		data, err := json.Marshal(m)
+		Check(err)
+		s = string(data)
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// report whether a pin of a gadget (or nested circuit) is an output
+func pinIsOutput(g *Gadget, pin string) bool {
+	if g == nil {
+		return false
+	}
+	name := strings.Split(pin, ":")[0]
+	if c, ok := g.circuitry.(*Circuit); ok {
+		internal := c.labels[name]
+		if internal == "" {
+			return false
+		}
+		return pinIsOutput(c.gadgets[gadgetPart(internal)], pinPart(internal))
+	}
+	v := reflect.ValueOf(g.circuitry)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	f, ok := v.Elem().Type().FieldByName(name)
+	return ok && f.Type != inputType
+}
+
+// return the keys of a string-keyed map in sorted order
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
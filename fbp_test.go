@@ -0,0 +1,45 @@
+package flow_test
+
+import (
+	"os"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleCircuit_LoadFBP() {
+	g := flow.NewCircuit()
+	err := g.LoadFBP([]byte(`
+		# repeat a string three times, then count the results
+		'3' -> NUM r(Repeater) OUT -> IN c(Counter)
+		'abc' -> IN r
+		c OUT -> IN p(Printer)
+	`))
+	if err != nil {
+		panic(err)
+	}
+	g.Run()
+	// Output:
+	// 3
+}
+
+func ExampleCircuit_WriteFBP() {
+	g := flow.NewCircuit()
+	g.Add("r", "Repeater")
+	g.Add("f", "FanOut")
+	g.Add("c", "Counter")
+	g.Connect("r.Out", "f.In", 0)
+	g.Connect("f.Out:x", "c.In", 2)
+	g.Feed("r.Num", 3)
+	g.Feed("r.In", "it's")
+	g.Label("In", "f.In")
+	g.Label("Count", "c.Out")
+	g.WriteFBP(os.Stdout)
+	// Output:
+	// 'it\'s' -> IN r(Repeater)
+	// '3' -> NUM r
+	// r OUT -> IN f(FanOut)
+	// f OUT[x] -> IN c(Counter) # capacity 2
+	// OUTPORT=c.OUT:Count
+	// INPORT=f.IN:In
+}
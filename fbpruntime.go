@@ -0,0 +1,495 @@
+package flow
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Version of the FBP network protocol implemented by FBPRuntime.
+const fbpProtocolVersion = "0.7"
+
+// An FBPRuntime lets FBP tools such as the NoFlo and Flowhub editors drive
+// circuits through the FBP network protocol over WebSocket. Components are
+// the registry entries, graphs are kept as definitions and turned into
+// circuits by the network "start" command, using Add, Connect, Feed, and
+// Label. Network events, including the data sent over each edge, are sent to
+// all connected clients.
+//
+// Browsers can only connect from pages on the runtime's own host, or from one
+// of the listed origins. When a secret is set, every message must include it
+// in its payload, the connection is closed on the first one which does not.
+type FBPRuntime struct {
+	ID      string   // runtime id reported to clients
+	Label   string   // human-readable name reported to clients
+	Secret  string   // required in all messages, if set
+	Origins []string // web pages which may connect, i.e. "https://app.flowhub.io"
+
+	mutex   sync.Mutex
+	graphs  map[string]*fbpGraph
+	clients map[*fbpClient]bool
+}
+
+// Messages queued for a client before it is considered too slow and dropped.
+const fbpClientQueue = 1000
+
+// a connected client, with the messages still to be written to it
+type fbpClient struct {
+	ws    *WebSocket
+	queue chan []byte
+}
+
+// queue a message, the connection is closed when the client can't keep up
+func (c *fbpClient) post(data []byte) {
+	select {
+	case c.queue <- data:
+	default:
+		glog.Warningln("fbp runtime: dropping slow client")
+		c.ws.conn.Close()
+	}
+}
+
+// write all queued messages, until the queue is closed
+func (c *fbpClient) writer() {
+	for data := range c.queue {
+		if err := c.ws.WriteMessage(data); err != nil {
+			c.ws.conn.Close() // also ends the reader, the rest is discarded
+		}
+	}
+	c.ws.Close()
+}
+
+// a graph which is being edited, and which may be running as circuit
+type fbpGraph struct {
+	conf    config
+	circuit *Circuit // non-nil while running
+	started time.Time
+}
+
+// one protocol message, in either direction
+type fbpMessage struct {
+	Protocol string      `json:"protocol"`
+	Command  string      `json:"command"`
+	Payload  interface{} `json:"payload"`
+}
+
+// a port reference in edges and initial packets, index is for output maps
+type fbpEndpoint struct {
+	Node  string      `json:"node"`
+	Port  string      `json:"port"`
+	Index interface{} `json:"index,omitempty"`
+}
+
+func (p fbpEndpoint) pin() string {
+	pin := p.Node + "." + p.Port
+	if p.Index != nil {
+		pin += fmt.Sprintf(":%v", p.Index)
+	}
+	return pin
+}
+
+// NewFBPRuntime returns a runtime without graphs.
+func NewFBPRuntime() *FBPRuntime {
+	return &FBPRuntime{
+		Label:   "Flow " + Version,
+		graphs:  map[string]*fbpGraph{},
+		clients: map[*fbpClient]bool{},
+	}
+}
+
+func (rt *FBPRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r, "noflo", rt.Origins)
+	if err != nil {
+		glog.Warningln("fbp runtime:", err)
+		return
+	}
+	client := &fbpClient{ws: ws, queue: make(chan []byte, fbpClientQueue)}
+	go client.writer()
+	rt.mutex.Lock()
+	rt.clients[client] = true
+	rt.mutex.Unlock()
+	defer func() {
+		rt.mutex.Lock()
+		delete(rt.clients, client)
+		rt.mutex.Unlock()
+		close(client.queue) // the writer closes the connection when done
+	}()
+
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg struct {
+			Protocol, Command string
+			Payload           json.RawMessage
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			rt.send(client, "runtime", "error", fbpError(err))
+			continue
+		}
+		if !rt.authorized(msg.Payload) {
+			glog.Warningln("fbp runtime: invalid secret from", r.RemoteAddr)
+			rt.send(client, msg.Protocol, "error",
+				fbpError(errors.New("invalid secret")))
+			return
+		}
+		if err := rt.handle(client, msg.Protocol, msg.Command, msg.Payload); err != nil {
+			rt.send(client, msg.Protocol, "error", fbpError(err))
+		}
+	}
+}
+
+// check the secret included in the payload of a message, if one is required
+func (rt *FBPRuntime) authorized(payload json.RawMessage) bool {
+	if rt.Secret == "" {
+		return true
+	}
+	var p struct{ Secret string }
+	json.Unmarshal(payload, &p)
+	return subtle.ConstantTimeCompare([]byte(p.Secret), []byte(rt.Secret)) == 1
+}
+
+func fbpError(err error) map[string]string {
+	return map[string]string{"message": err.Error()}
+}
+
+// send a message to one client, or to all clients if c is nil, this never
+// blocks since each client has its own queue
+func (rt *FBPRuntime) send(c *fbpClient, protocol, command string, payload interface{}) {
+	data, err := json.Marshal(fbpMessage{protocol, command, payload})
+	if err != nil {
+		glog.Errorln("fbp runtime:", err)
+		return
+	}
+	if c != nil {
+		c.post(data)
+		return
+	}
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	for client := range rt.clients {
+		client.post(data)
+	}
+}
+
+func (rt *FBPRuntime) handle(client *fbpClient, protocol, command string, payload json.RawMessage) error {
+	switch protocol {
+	case "runtime":
+		if command != "getruntime" {
+			return fmt.Errorf("unsupported command: %s", command)
+		}
+		caps := []string{"protocol:runtime", "protocol:component",
+			"protocol:graph", "protocol:network", "network:data"}
+		rt.send(client, "runtime", "runtime", map[string]interface{}{
+			"type":            "flow",
+			"version":         fbpProtocolVersion,
+			"id":              rt.ID,
+			"label":           rt.Label,
+			"capabilities":    caps,
+			"allCapabilities": caps,
+		})
+		return nil
+	case "component":
+		if command != "list" {
+			return fmt.Errorf("unsupported command: %s", command)
+		}
+		entries := Catalog(Registry)
+		for _, e := range entries {
+			rt.send(client, "component", "component", fbpComponent(e))
+		}
+		rt.send(client, "component", "componentsready", len(entries))
+		return nil
+	case "graph":
+		return rt.editGraph(client, command, payload)
+	case "network":
+		return rt.controlNetwork(client, command, payload)
+	}
+	return fmt.Errorf("unsupported protocol: %s", protocol)
+}
+
+// describe a registry entry as FBP component
+func fbpComponent(e CatalogEntry) map[string]interface{} {
+	ports := func(pins []CatalogPin, inputs bool) []map[string]interface{} {
+		list := []map[string]interface{}{}
+		for _, p := range pins {
+			port := map[string]interface{}{"id": p.Name, "type": "all"}
+			if inputs {
+				port["required"] = !p.Optional
+			} else {
+				port["addressable"] = p.Map
+			}
+			list = append(list, port)
+		}
+		return list
+	}
+	return map[string]interface{}{
+		"name":        e.Name,
+		"description": e.Help,
+		"subgraph":    e.Circuit,
+		"inPorts":     ports(e.Inputs, true),
+		"outPorts":    ports(e.Outputs, false),
+	}
+}
+
+// apply one graph edit, and echo it back to the client on success
+func (rt *FBPRuntime) editGraph(client *fbpClient, command string, payload json.RawMessage) error {
+	var p struct {
+		ID, Graph, Component, Public, Node, Port, From, To string
+		Src, Tgt                                           fbpEndpoint
+	}
+	var initial struct {
+		Src struct{ Data interface{} }
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	json.Unmarshal(payload, &initial)
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if command == "clear" {
+		rt.graphs[p.ID] = &fbpGraph{}
+		rt.send(client, "graph", command, payload)
+		return nil
+	}
+	g, ok := rt.graphs[p.Graph]
+	if !ok {
+		return fmt.Errorf("graph not found: %s", p.Graph)
+	}
+	conf := &g.conf
+
+	switch command {
+	case "addnode":
+		if Registry[p.Component] == nil {
+			return fmt.Errorf("component not found: %s", p.Component)
+		}
+		for _, gd := range conf.Gadgets {
+			if gd.Name == p.ID {
+				return fmt.Errorf("node already exists: %s", p.ID)
+			}
+		}
+		conf.Gadgets = append(conf.Gadgets, gadgetDef{Name: p.ID,
+			Type: p.Component})
+	case "removenode":
+		conf.renameNode(p.ID, "")
+	case "renamenode":
+		conf.renameNode(p.From, p.To)
+	case "addedge":
+		conf.Wires = append(conf.Wires, wireDef{From: p.Src.pin(),
+			To: p.Tgt.pin()})
+	case "removeedge":
+		wires := conf.Wires[:0]
+		for _, w := range conf.Wires {
+			if w.From != p.Src.pin() || w.To != p.Tgt.pin() {
+				wires = append(wires, w)
+			}
+		}
+		conf.Wires = wires
+	case "addinitial":
+		conf.Feeds = append(conf.Feeds, feedDef{Data: initial.Src.Data,
+			To: p.Tgt.pin()})
+	case "removeinitial":
+		feeds := conf.Feeds[:0]
+		for _, f := range conf.Feeds {
+			if f.To != p.Tgt.pin() {
+				feeds = append(feeds, f)
+			}
+		}
+		conf.Feeds = feeds
+	case "addinport", "addoutport":
+		conf.Labels = append(conf.Labels, labelDef{p.Public,
+			p.Node + "." + p.Port})
+	case "removeinport", "removeoutport":
+		labels := conf.Labels[:0]
+		for _, l := range conf.Labels {
+			if l.External != p.Public {
+				labels = append(labels, l)
+			}
+		}
+		conf.Labels = labels
+	case "renameinport", "renameoutport":
+		for i, l := range conf.Labels {
+			if l.External == p.From {
+				conf.Labels[i].External = p.To
+			}
+		}
+	case "changenode", "changeedge", "addgroup", "removegroup",
+		"renamegroup", "changegroup":
+		// only metadata, which is not kept
+	default:
+		return fmt.Errorf("unsupported command: %s", command)
+	}
+	rt.send(client, "graph", command, payload)
+	return nil
+}
+
+// rename a node in all places where it is used, or remove it if to is empty
+func (conf *config) renameNode(from, to string) {
+	rename := func(pin string) (string, bool) {
+		if gadgetPart(pin) != from {
+			return pin, true
+		}
+		return to + "." + pinPart(pin), to != ""
+	}
+	gadgets := conf.Gadgets[:0]
+	for _, g := range conf.Gadgets {
+		if g.Name == from {
+			if to == "" {
+				continue
+			}
+			g.Name = to
+		}
+		gadgets = append(gadgets, g)
+	}
+	conf.Gadgets = gadgets
+	wires := conf.Wires[:0]
+	for _, w := range conf.Wires {
+		var keepFrom, keepTo bool
+		w.From, keepFrom = rename(w.From)
+		w.To, keepTo = rename(w.To)
+		if keepFrom && keepTo {
+			wires = append(wires, w)
+		}
+	}
+	conf.Wires = wires
+	feeds := conf.Feeds[:0]
+	for _, f := range conf.Feeds {
+		var keep bool
+		if f.To, keep = rename(f.To); keep {
+			feeds = append(feeds, f)
+		}
+	}
+	conf.Feeds = feeds
+	labels := conf.Labels[:0]
+	for _, l := range conf.Labels {
+		var keep bool
+		if l.Internal, keep = rename(l.Internal); keep {
+			labels = append(labels, l)
+		}
+	}
+	conf.Labels = labels
+}
+
+func (rt *FBPRuntime) controlNetwork(client *fbpClient, command string, payload json.RawMessage) error {
+	var p struct{ Graph string }
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	g, ok := rt.graphs[p.Graph]
+	if !ok {
+		return fmt.Errorf("graph not found: %s", p.Graph)
+	}
+
+	switch command {
+	case "start":
+		if g.circuit != nil {
+			return fmt.Errorf("already running: %s", p.Graph)
+		}
+		conf := g.conf
+		if err := conf.validate(nil); err != nil {
+			return err
+		}
+		c := NewCircuit()
+		if err := c.build(&conf); err != nil {
+			return err
+		}
+		g.circuit, g.started = c, time.Now()
+		finished := make(chan struct{})
+		var taps sync.WaitGroup
+		for _, w := range conf.Wires {
+			tap, untap, err := c.Tap(w.To, streamCapacity)
+			if err != nil {
+				continue
+			}
+			taps.Add(1)
+			go rt.forward(p.Graph, w, tap, finished, func() {
+				untap()
+				taps.Done()
+			})
+		}
+		rt.send(client, "network", "started", rt.status(p.Graph, g))
+		go func() {
+			c.Run()
+			close(finished)
+			taps.Wait()
+			rt.mutex.Lock()
+			g.circuit = nil
+			status := rt.status(p.Graph, g)
+			rt.mutex.Unlock()
+			rt.send(nil, "network", "stopped", status)
+		}()
+	case "stop":
+		if g.circuit == nil {
+			return fmt.Errorf("not running: %s", p.Graph)
+		}
+		g.circuit.Stop() // "stopped" is sent once the circuit has ended
+	case "getstatus":
+		rt.send(client, "network", "status", rt.status(p.Graph, g))
+	case "debug":
+		rt.send(client, "network", "debug", payload)
+	default:
+		return fmt.Errorf("unsupported command: %s", command)
+	}
+	return nil
+}
+
+func (rt *FBPRuntime) status(id string, g *fbpGraph) map[string]interface{} {
+	running := g.circuit != nil
+	status := map[string]interface{}{
+		"graph":   id,
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"running": running,
+		"started": running,
+	}
+	if running {
+		status["uptime"] = int(time.Since(g.started).Seconds())
+	}
+	return status
+}
+
+// send a data event for each message on a tapped edge, until it's finished
+func (rt *FBPRuntime) forward(graph string, w wireDef, tap <-chan Message, finished chan struct{}, done func()) {
+	defer done()
+	port := func(pin string) fbpEndpoint {
+		p := fbpEndpoint{Node: gadgetPart(pin), Port: pinPart(pin)}
+		if n := strings.IndexByte(p.Port, ':'); n >= 0 {
+			p.Port, p.Index = p.Port[:n], p.Port[n+1:]
+		}
+		return p
+	}
+	event := map[string]interface{}{
+		"id":    w.From + " -> " + w.To,
+		"src":   port(w.From),
+		"tgt":   port(w.To),
+		"graph": graph,
+	}
+	send := func(m Message) {
+		event["data"] = m
+		rt.send(nil, "network", "data", event)
+	}
+	for {
+		select {
+		case m := <-tap:
+			send(m)
+		case <-finished:
+			for {
+				select {
+				case m := <-tap:
+					send(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package flow_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleFBPRuntime() {
+	rt := flow.NewFBPRuntime()
+	rt.Secret = "s3cret"
+	ts := httptest.NewServer(rt)
+	defer ts.Close()
+	url := strings.Replace(ts.URL, "http:", "ws:", 1)
+	ws, err := flow.DialWebSocket(url, "noflo")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer ws.Close()
+
+	send := func(protocol, command, payload string) {
+		payload = strings.Replace(payload, "{", `{"secret":"s3cret",`, 1)
+		payload = strings.Replace(payload, ",}", "}", 1)
+		ws.WriteMessage([]byte(fmt.Sprintf(
+			`{"protocol":%q,"command":%q,"payload":%s}`,
+			protocol, command, payload)))
+	}
+	receive := func() (string, map[string]interface{}) {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return err.Error(), nil
+		}
+		var msg struct {
+			Protocol, Command string
+			Payload           interface{}
+		}
+		json.Unmarshal(data, &msg)
+		payload, _ := msg.Payload.(map[string]interface{})
+		return msg.Protocol + ":" + msg.Command, payload
+	}
+
+	send("runtime", "getruntime", `{}`)
+	cmd, p := receive()
+	fmt.Println(cmd, p["type"], p["version"])
+
+	send("component", "list", `{}`)
+	for {
+		cmd, p = receive()
+		if cmd != "component:component" {
+			break
+		}
+		if p["name"] == "Printer" {
+			fmt.Println(cmd, p["name"], p["inPorts"])
+		}
+	}
+	fmt.Println(cmd)
+
+	send("graph", "clear", `{"id":"g"}`)
+	send("graph", "addnode", `{"id":"a","component":"Pipe","graph":"g"}`)
+	send("graph", "addnode", `{"id":"b","component":"Sink","graph":"g"}`)
+	send("graph", "addnode", `{"id":"c","component":"NoSuchThing","graph":"g"}`)
+	send("graph", "addedge", `{"src":{"node":"a","port":"Out"},
+		"tgt":{"node":"b","port":"In"},"graph":"g"}`)
+	send("graph", "addinitial", `{"src":{"data":"hello"},
+		"tgt":{"node":"a","port":"In"},"graph":"g"}`)
+	for i := 0; i < 6; i++ {
+		cmd, p = receive()
+		fmt.Println(cmd, p["message"])
+	}
+
+	send("network", "start", `{"graph":"g"}`)
+	for i := 0; i < 3; i++ {
+		cmd, p = receive()
+		fmt.Println(cmd, p["running"], p["id"], p["data"])
+	}
+
+	// the connection is closed after a message with the wrong secret
+	ws2, _ := flow.DialWebSocket(url, "noflo")
+	ws2.WriteMessage([]byte(`{"protocol":"runtime","command":"getruntime",
+		"payload":{"secret":"guess"}}`))
+	data, _ := ws2.ReadMessage()
+	fmt.Println(string(data))
+	_, err = ws2.ReadMessage()
+	fmt.Println(err != nil)
+
+	// web pages from elsewhere cannot connect
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://elsewhere.example")
+	res, err := http.DefaultClient.Do(req)
+	if err == nil {
+		res.Body.Close()
+		fmt.Println(res.Status)
+	}
+	// Output:
+	// runtime:runtime flow 0.7
+	// component:component Printer [map[id:In required:true type:all]]
+	// component:componentsready
+	// graph:clear <nil>
+	// graph:addnode <nil>
+	// graph:addnode <nil>
+	// graph:error component not found: NoSuchThing
+	// graph:addedge <nil>
+	// graph:addinitial <nil>
+	// network:started true <nil> <nil>
+	// network:data <nil> a.Out -> b.In hello
+	// network:stopped false <nil> <nil>
+	// {"protocol":"runtime","command":"error","payload":{"message":"invalid secret"}}
+	// true
+	// 403 Forbidden
+}
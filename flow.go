@@ -4,12 +4,13 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"reflect"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/golang/glog"
 )
@@ -20,6 +21,13 @@ var Version = "0.4.0"
 // The registry is the factory for all known types of gadgets.
 var Registry = map[string]func() Circuitry{}
 
+// Help has a short description for registry entries, to show in catalogs.
+var Help = map[string]string{}
+
+// FeedChecks validate the data fed to a pin when definitions are checked or
+// linted, by gadget type and pin name, e.g. "Map.Expr".
+var FeedChecks = map[string]func(Message) error{}
+
 // Config stores configuration settings for general use.
 var Config = map[string]string{}
 
@@ -51,23 +59,61 @@ type Circuitry interface {
 
 // A wire is a ref-counted Input, it's closed when the count drops to 0.
 type wire struct {
+	count    int64 // number of messages sent, updated atomically
+	tapped   int32 // number of taps, updated atomically
+	backlog  int64 // messages held by a priority or journal pump, atomically
 	channel  chan Message
+	closed   bool // the current channel has been closed
 	senders  int
 	capacity int
+	priority bool
+	journal  *journal
 	dest     *Gadget
+	pin      string                // name of the input pin on dest
+	handover bool                  // close the previous channel once replaced
+	quit     chan struct{}         // closed when the receiving gadget has ended
+	taps     map[chan Message]bool // listeners which get a copy of each message
+	route    atomic.Value          // current destination gadget, as a *Gadget
+	mutex    sync.RWMutex          // held for reading while sending
+}
+
+// make the current destination of a wire visible to senders, the channel is
+// always looked up with the mutex held, so it can't be closed during a send
+func (c *wire) publish() {
+	c.route.Store(c.dest)
 }
 
 func (c *wire) Send(v Message) {
-	c.dest.sendTo(c, v)
+	c.route.Load().(*Gadget).sendTo(c, v)
 }
 
 func (c *wire) Disconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.senders == 0 {
+		return // already closed, i.e. when the circuit was stopped
+	}
 	c.senders--
-	if c.senders == 0 && c.channel != nil {
+	if c.senders == 0 {
+		c.closeChannel()
+	}
+}
+
+// close the current channel if that hasn't happened yet, this must be called
+// with the mutex held for writing, so that no send is in progress
+func (c *wire) closeChannel() {
+	if c.channel != nil && !c.closed {
 		close(c.channel)
+		c.closed = true
 	}
 }
 
+// number of messages waiting to be received by the gadget, including those
+// held in a priority queue or in a journal, the mutex must be held
+func (c *wire) queued() int {
+	return len(c.channel) + int(atomic.LoadInt64(&c.backlog))
+}
+
 // Use a fake sink for every output pin not connected to anything else.
 type fakeSink struct{}
 
@@ -96,6 +142,27 @@ func Check(err interface{}) {
 	}
 }
 
+// Utility for gadgets which can't do their work, e.g. due to bad settings: log
+// the args as error, then drop all messages arriving on the input until it is
+// closed, so that senders never block on it.
+func DrainOnError(in Input, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintln(args...)) // logged as from the caller
+	for range in {
+	}
+}
+
+// Utility to get an integer setting from a message, which can be an int or a
+// float64, as decoded from JSON. Returns false if it's not a number.
+func IntValue(m Message) (int, bool) {
+	switch v := m.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
 // Call this as "defer flow.DontPanic()" for a concise stack trace on panics.
 func DontPanic() {
 	// generate a nice stack trace, see https://code.google.com/p/gonicetrace/
@@ -117,31 +184,70 @@ func DontPanic() {
 }
 
 // AddToRegistry adds circuit definitions from a JSON file to the registry.
+// The file can list other files to include in an "imports" array, as paths
+// or glob patterns relative to the importing file, or as objects with a path
+// and an "as" namespace to prefix the imported names with, i.e. "ns.Name".
+// Import cycles and duplicate circuit names are reported as error, also when
+// a file added before defined the same name differently.
 func AddToRegistry(filename string) error {
-	data, err := ioutil.ReadFile(filename)
+	set, err := loadDefinitions(filename, nil)
 	if err != nil {
 		return err
 	}
-	var definitions map[string]json.RawMessage
-	err = json.Unmarshal(data, &definitions)
-	if err != nil {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if err := set.checkRegistered(filename); err != nil {
 		return err
 	}
-	for name, def := range definitions {
+	for name, def := range set.defs {
 		registerCircuit(name, def)
+		defOrigins[name] = set.origin[name]
 	}
+	defFiles[filename] = sortedKeys(set.defs)
 	return nil
 }
 
+// circuit definitions loaded into the registry, by name
+var circuitDefs = map[string][]byte{}
+
+// names of the circuits registered from each definitions file
+var defFiles = map[string][]string{}
+
+// where each registered circuit was defined, as "file:line"
+var defOrigins = map[string]string{}
+
 func registerCircuit(name string, def []byte) {
+	circuitDefs[name] = def
+	var conf config
+	if json.Unmarshal(def, &conf) == nil && conf.Process != nil {
+		Registry[name] = func() Circuitry {
+			conf, err := parseDefinition(def, nil) // expands the settings
+			Check(err)
+			return &Process{def: *conf.Process}
+		}
+		return
+	}
 	Registry[name] = func() Circuitry {
-		g := NewCircuit()
-		err := g.LoadJSON(def)
+		g, err := instantiate(name, nil)
 		Check(err)
 		return g
 	}
 }
 
+// create a circuit from a registered definition, with the given parameters
+func instantiate(name string, params map[string]interface{}) (*Circuit, error) {
+	def := circuitDefs[name]
+	if def == nil {
+		return nil, fmt.Errorf("no parameters allowed for: %s", name)
+	}
+	g := NewCircuit()
+	if err := g.loadJSON(def, params); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	g.defName = name
+	return g, nil
+}
+
 // Print a compact list of the registry entries on standard output.
 func PrintRegistry() {
 	keys := []string{}
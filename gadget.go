@@ -3,6 +3,8 @@ package flow
 import (
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -13,9 +15,13 @@ type Gadget struct {
 	circuitry Circuitry        // pointer to self as a Circuitry object
 	name      string           // name of this gadget in the circuit
 	owner     *Circuit         // owning circuit
-	alive     bool             // true while running
+	alive     int32            // non-zero while running, accessed atomically
 	inputs    map[string]*wire // inbound wires
 	outputs   map[string]*wire // outbound wires
+	control   chan Message     // out-of-band control messages
+	quit      chan struct{}    // closed when Run returns
+	retired   bool             // replaced by a reload, never launched again
+	mutex     sync.Mutex       // prevents launching the gadget twice
 }
 
 func (g *Gadget) initGadget(cy Circuitry, nm string, ow *Circuit) *Gadget {
@@ -27,6 +33,7 @@ func (g *Gadget) initGadget(cy Circuitry, nm string, ow *Circuit) *Gadget {
 	g.owner = ow
 	g.inputs = map[string]*wire{}
 	g.outputs = map[string]*wire{}
+	g.control = make(chan Message, controlCapacity)
 	return g
 }
 
@@ -38,7 +45,9 @@ func (g *Gadget) pinValue(pin string) reflect.Value {
 	pp := pinPart(pin)
 	// if it's a circuit, look up mapped pins
 	if g, ok := g.circuitry.(*Circuit); ok {
+		g.lock.RLock()
 		p := g.labels[pp]
+		g.lock.RUnlock()
 		return g.gadgetOf(p).circuitry.pinValue(p) // recursive
 	}
 	fv := g.gadgetValue().FieldByName(pp)
@@ -51,12 +60,15 @@ func (g *Gadget) pinValue(pin string) reflect.Value {
 func (g *Gadget) getInput(pin string, capacity int) *wire {
 	c := g.inputs[pin]
 	if c == nil {
-		c = &wire{channel: make(chan Message, capacity), dest: g}
+		c = &wire{channel: make(chan Message, capacity), dest: g, pin: pin}
+		c.publish()
 		g.inputs[pin] = c
 	}
+	c.mutex.Lock() // the wire may be in use, if the circuit is being patched
 	if capacity > c.capacity {
 		c.capacity = capacity
 	}
+	c.mutex.Unlock()
 	return c
 }
 
@@ -78,31 +90,70 @@ func (g *Gadget) setOutput(pin string, c *wire) {
 		}
 		outputs[ppfv[1]] = c
 	}
+	c.mutex.Lock()
 	c.senders++
+	c.mutex.Unlock()
 	g.outputs[pin] = c
 }
 
 func (g *Gadget) setupChannels() {
 	// make sure all the feed wires have also been set up
+	feeds := map[string][]Message{}
+	g.owner.lock.RLock() // feeds can change while a circuit is being patched
 	for dest, msgs := range g.owner.feeds {
 		if gadgetPart(dest) == g.name {
-			g.getInput(dest, len(msgs)) // will add wire to the inputs map
+			feeds[pinPart(dest)] = msgs
 		}
 	}
+	g.owner.lock.RUnlock()
+	for pin, msgs := range feeds {
+		g.getInput(pin, len(msgs)) // adds wire to the inputs map
+	}
 
 	// set up and pre-fill all the input pins
+	var late []pendingControl
 	for pin, wire := range g.inputs {
+		var data []Message
+		data, late = wire.divertControl(feeds[pin], late)
+		if wire.journal != nil {
+			// durable wires queue messages themselves, including the feeds
+			setValue(g.circuitry.pinValue(pin), wire.persist(data, g.quit))
+			continue
+		}
 		// create a channel with the proper capacity
+		wire.mutex.Lock()
+		previous, wasClosed := wire.channel, wire.closed
 		wire.channel = make(chan Message, wire.capacity)
-		setValue(g.circuitry.pinValue(pin), wire.channel)
+		wire.closed = false
+		wire.quit = g.quit
 		// fill it with messages from the feed inbox, if any
-		for _, msg := range g.owner.feeds[pin] {
-			wire.channel <- msg
+		for _, msg := range data {
+			if m, ok := wire.filter(msg); ok {
+				wire.channel <- m
+			}
+		}
+		if wire.priority {
+			setValue(g.circuitry.pinValue(pin), wire.prioritize(g.quit))
+		} else {
+			setValue(g.circuitry.pinValue(pin), wire.channel)
+		}
+		wire.publish()
+		// a wire taken over from a replaced gadget lets that one finish, no
+		// sends are in progress, and new ones will only see the new channel
+		if wire.handover {
+			wire.handover = false
+			if !wasClosed {
+				close(previous)
+			}
 		}
 		// close the channel if there is no other feed
 		if wire.senders == 0 {
-			close(wire.channel)
+			wire.closeChannel()
 		}
+		wire.mutex.Unlock()
+	}
+	if len(late) > 0 {
+		go deliverControl(late, g.quit, g.owner.done)
 	}
 
 	// set dangling inputs to a null input and dangling outputs to a fake sink
@@ -137,45 +188,99 @@ func (g *Gadget) closeChannels() {
 	for _, wire := range g.outputs {
 		wire.Disconnect()
 	}
+	g.mutex.Lock() // the inputs change when a circuit is patched
+	inputs := make([]*wire, 0, len(g.inputs))
 	for _, wire := range g.inputs {
-		// close channel if not nil and not already closed
-		if wire.channel != nil {
-			select {
-			case _, ok := <-wire.channel:
-				if ok {
-					close(wire.channel)
-				}
-			default:
-			}
+		inputs = append(inputs, wire)
+	}
+	g.mutex.Unlock()
+	for _, wire := range inputs {
+		// close the channel if messages are left which will never be taken
+		wire.mutex.Lock()
+		if wire.journal == nil && len(wire.channel) > 0 {
+			wire.closeChannel()
 		}
-		// setValue(g.circuitry.pinValue(pin), wire.channel)
+		wire.mutex.Unlock()
 	}
 }
 
 func (g *Gadget) sendTo(w *wire, v Message) {
-	if !g.alive {
-		g.launch()
+	if atomic.LoadInt32(&g.owner.dead) != 0 {
+		glog.V(1).Infoln("dropped after stop:", g.name+"."+w.pin, v)
+		return
+	}
+	if atomic.LoadInt32(&g.alive) == 0 && !g.launch() {
+		// replaced by a reload, the wire now leads to another gadget
+		if dest := w.route.Load().(*Gadget); dest != g {
+			dest.sendTo(w, v)
+			return
+		}
 	}
 
+	// the channel can't be closed or replaced while the read lock is held
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	v, ok := w.filter(v)
+	if !ok {
+		return
+	}
+
+	atomic.AddInt64(&w.count, 1)
+	if atomic.LoadInt32(&w.tapped) > 0 {
+		w.copyToTaps(v)
+	}
+
+	if w.closed {
+		glog.Warningln("dropped, input is closed:", g.name+"."+w.pin, v)
+		return
+	}
+	g.deliver(w.channel, w.quit, v)
+}
+
+// put a message on a channel, the message is dropped once the circuit has
+// been stopped or the receiving gadget has ended
+func (g *Gadget) deliver(ch chan Message, quit chan struct{}, v Message) {
 	const reportSlowSends = false
 	if reportSlowSends {
 		for {
 			select {
-			case w.channel <- v:
+			case ch <- v:
 				return // send ok
 			case <-time.After(10 * time.Second):
 				glog.Errorln("send timed out", g.name, v)
 			}
 		}
-	} else {
-		w.channel <- v
+	}
+	select {
+	case ch <- v:
+		return
+	default: // avoid the cost of a full select when there is room
+	}
+	select {
+	case ch <- v:
+	case <-g.owner.done:
+		glog.V(1).Infoln("dropped after stop:", g.name, v)
+	case <-quit:
+		glog.Warningln("dropped, gadget has ended:", g.name, v)
 	}
 }
 
-func (g *Gadget) launch() {
-	g.alive = true
+// start the gadget if it's not running yet, returns false if it has been
+// retired and will not run again
+func (g *Gadget) launch() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.retired {
+		return false
+	}
+	if atomic.LoadInt32(&g.alive) != 0 {
+		return true
+	}
 	g.owner.wait.Add(1)
+	g.quit = make(chan struct{})
 	g.setupChannels()
+	quit := g.quit
+	atomic.StoreInt32(&g.alive, 1) // only now can others skip the mutex
 
 	go func() {
 		defer DontPanic()
@@ -189,8 +294,12 @@ func (g *Gadget) launch() {
 		// 	}
 		// }
 
-		g.alive = false
+		g.mutex.Lock()
+		atomic.StoreInt32(&g.alive, 0)
+		close(quit)
+		g.mutex.Unlock()
 	}()
+	return true
 }
 
 func setValue(value reflect.Value, any interface{}) {
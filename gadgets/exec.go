@@ -0,0 +1,150 @@
+package gadgets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/jcw/flow"
+)
+
+func init() {
+	flow.Registry["Exec"] = func() flow.Circuitry { return new(Exec) }
+	flow.Help["Exec"] = "Run the command set on Cmd once for each message."
+	flow.RegisterType("ExecResult", ExecResult{})
+}
+
+// ExecResult is the outcome of one command run by the Exec gadget.
+type ExecResult struct {
+	Args   []string     // the command, after filling in the message
+	Msg    flow.Message // the message which triggered this run
+	Code   int          // exit code, or -1 if the command did not finish
+	Stdout string
+	Stderr string
+	Error  string `json:",omitempty"` // set if the command failed
+}
+
+// Exec runs a command for each incoming message. The command is set on Cmd,
+// as a string with space-separated arguments or as a list, where each argument
+// is a text/template with the message as "dot", e.g. "gzip -k {{.}}". Workers
+// sets how many commands can run at the same time (default 1), and Timeout
+// limits how long each one can take. An ExecResult is sent to Out for each
+// command which exits normally, and to Err for each one which fails or exits
+// with a non-zero code. With more than one worker, results can be sent out in
+// a different order than the messages came in. Tags are passed through.
+// Registers as "Exec".
+type Exec struct {
+	flow.Gadget
+	Cmd     flow.Input
+	Workers flow.Input `flow:"optional"`
+	Timeout flow.Input `flow:"optional"`
+	In      flow.Input
+	Out     flow.Output
+	Err     flow.Output
+}
+
+// Start running commands, once the command template is known. Errors in the
+// settings are logged, and all input is then dropped.
+func (g *Exec) Run() {
+	var args []*template.Template
+	var cmd []string
+	switch v := (<-g.Cmd).(type) {
+	case string:
+		cmd = strings.Fields(v)
+	case []interface{}:
+		for _, s := range v {
+			cmd = append(cmd, fmt.Sprint(s))
+		}
+	}
+	for _, s := range cmd {
+		t, err := template.New("arg").Parse(s)
+		if err != nil {
+			flow.DrainOnError(g.In, "Exec: bad command:", err)
+			return
+		}
+		args = append(args, t)
+	}
+	if len(args) == 0 {
+		flow.DrainOnError(g.In, "Exec: no command")
+		return
+	}
+	workers := 1
+	if m, ok := <-g.Workers; ok {
+		if workers, _ = flow.IntValue(m); workers <= 0 {
+			flow.DrainOnError(g.In, "Exec: workers must be a positive number:", m)
+			return
+		}
+	}
+	var timeout time.Duration
+	if m, ok := <-g.Timeout; ok {
+		s, _ := m.(string)
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			flow.DrainOnError(g.In, "Exec: bad timeout:", m)
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range g.In {
+				if _, ok := m.(flow.Tag); ok {
+					g.Out.Send(m)
+					continue
+				}
+				r := runCommand(args, m, timeout)
+				if r.Error != "" {
+					g.Err.Send(r)
+				} else {
+					g.Out.Send(r)
+				}
+			}
+		}()
+	}
+}
+
+// fill in the command template with a message and run it
+func runCommand(args []*template.Template, m flow.Message, timeout time.Duration) ExecResult {
+	r := ExecResult{Msg: m, Code: -1}
+	for _, t := range args {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, m); err != nil {
+			r.Error = err.Error()
+			return r
+		}
+		r.Args = append(r.Args, buf.String())
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.Args[0], r.Args[1:]...)
+	killGroup(cmd)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	r.Stdout, r.Stderr = stdout.String(), stderr.String()
+	if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
+		r.Code = cmd.ProcessState.ExitCode()
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		r.Error = "timeout after " + timeout.String()
+	case err != nil:
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,8 @@
+//go:build !unix || !go1.20
+
+package gadgets
+
+import "os/exec"
+
+// process groups are not available, only the command itself is killed
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,65 @@
+package gadgets
+
+import (
+	"fmt"
+
+	"github.com/jcw/flow"
+)
+
+// prints the interesting parts of each command result
+type resultPrinter struct {
+	flow.Gadget
+	In flow.Input
+}
+
+func (g *resultPrinter) Run() {
+	for m := range g.In {
+		r, ok := m.(ExecResult)
+		if !ok {
+			fmt.Println("other:", m)
+			continue
+		}
+		fmt.Printf("%v %d %q %q\n", r.Msg, r.Code, r.Stdout, r.Error)
+	}
+}
+
+func ExampleExec() {
+	g := flow.NewCircuit()
+	g.Add("e", "Exec")
+	g.AddCircuitry("p", new(resultPrinter))
+	g.Connect("e.Out", "p.In", 0)
+	g.Feed("e.Cmd", []interface{}{"sh", "-c", "echo {{.}}; exit {{.}}"})
+	g.Feed("e.In", 0)
+	g.Feed("e.In", flow.Tag{"<eof>", nil})
+	g.Run()
+	// Output:
+	// 0 0 "0\n" ""
+	// other: {<eof> <nil>}
+}
+
+func ExampleExec_err() {
+	g := flow.NewCircuit()
+	g.Add("e", "Exec")
+	g.AddCircuitry("p", new(resultPrinter))
+	g.Connect("e.Err", "p.In", 0)
+	g.Feed("e.Cmd", []interface{}{"sh", "-c", "sleep {{.}} && echo done"})
+	g.Feed("e.Timeout", "100ms")
+	g.Feed("e.Workers", 2.0) // as in a definition file
+	g.Feed("e.In", "3")
+	g.Feed("e.In", "x")
+	g.Run()
+	// Unordered output:
+	// 3 -1 "" "timeout after 100ms"
+	// x 1 "" "exit status 1"
+}
+
+func ExampleExec_badCommand() {
+	g := flow.NewCircuit()
+	g.Add("e", "Exec")
+	g.Feed("e.Cmd", "echo {{.")
+	g.Feed("e.In", 1)
+	g.Run()
+	fmt.Println("input dropped")
+	// Output:
+	// input dropped
+}
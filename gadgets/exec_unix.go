@@ -0,0 +1,19 @@
+//go:build unix && go1.20
+
+package gadgets
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// run a command in its own process group, and kill the whole group when it
+// times out, so that the commands started by a shell end as well
+func killGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second // for output held open by stray processes
+}
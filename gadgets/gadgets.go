@@ -3,9 +3,11 @@ package gadgets
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -34,6 +36,27 @@ func init() {
 	flow.Registry["CmdLine"] = func() flow.Circuitry { return new(CmdLine) }
 	flow.Registry["Concat3"] = func() flow.Circuitry { return new(Concat3) }
 	flow.Registry["AddTag"] = func() flow.Circuitry { return new(AddTag) }
+	flow.Registry["WriteFileMessages"] = func() flow.Circuitry { return new(WriteFileMessages) }
+	flow.Registry["ReadFileMessages"] = func() flow.Circuitry { return new(ReadFileMessages) }
+
+	flow.Help["Sink"] = "Eat up all the messages it receives."
+	flow.Help["Repeater"] = "Repeat each message a number of times, set by Num."
+	flow.Help["Counter"] = "Report the number of messages received, at the end."
+	flow.Help["Printer"] = "Print each incoming message on standard output."
+	flow.Help["Timer"] = "Send one message after the time set on In."
+	flow.Help["Clock"] = "Send out messages at the fixed rate set on In."
+	flow.Help["FanOut"] = "Send each message out to all outputs in the Out map."
+	flow.Help["Forever"] = "Run forever, without ever sending anything."
+	flow.Help["Delay"] = "Send each message out after the delay set on Delay."
+	flow.Help["TimeStamp"] = "Insert a timestamp before each message."
+	flow.Help["ReadFileText"] = "Replace file names by the lines of the file."
+	flow.Help["ReadFileJSON"] = "Replace file names by the file's decoded JSON."
+	flow.Help["EnvVar"] = "Look up environment variables, with optional default."
+	flow.Help["CmdLine"] = "Turn command-line arguments into messages."
+	flow.Help["Concat3"] = "Concatenate the messages from three input pins."
+	flow.Help["AddTag"] = "Turn each message into a tag, named by Tag."
+	flow.Help["WriteFileMessages"] = "Save all messages to the file named by Name."
+	flow.Help["ReadFileMessages"] = "Replace file names by the messages saved in them."
 }
 
 // A sink eats up all the messages it receives. Registers as "Sink".
@@ -98,6 +121,16 @@ func (w *Counter) Run() {
 	w.Out.Send(w.count)
 }
 
+// Save the current count, so it can be restored after a restart.
+func (w *Counter) SaveState() ([]byte, error) {
+	return json.Marshal(w.count)
+}
+
+// Restore a previously saved count.
+func (w *Counter) LoadState(data []byte) error {
+	return json.Unmarshal(data, &w.count)
+}
+
 // Printers report the messages sent to them as output. Registers as "Printer".
 type Printer struct {
 	flow.Gadget
@@ -124,8 +157,11 @@ func (w *Timer) Run() {
 	if r, ok := <-w.In; ok {
 		rate, err := time.ParseDuration(r.(string))
 		flow.Check(err)
-		t := <-time.After(rate)
-		w.Out.Send(t)
+		select {
+		case t := <-time.After(rate):
+			w.Out.Send(t)
+		case <-w.Done():
+		}
 	}
 }
 
@@ -144,8 +180,13 @@ func (w *Clock) Run() {
 		flow.Check(err)
 		t := time.NewTicker(rate)
 		defer t.Stop()
-		for m := range t.C {
-			w.Out.Send(m)
+		for {
+			select {
+			case m := <-t.C:
+				w.Out.Send(m)
+			case <-w.Done():
+				return
+			}
 		}
 	}
 }
@@ -173,9 +214,9 @@ type Forever struct {
 	Out flow.Output
 }
 
-// Start running forever, the output stays open and never sends anything.
+// Start running until stopped, the output stays open and never sends anything.
 func (w *Forever) Run() {
-	<-make(chan struct{})
+	<-w.Done()
 }
 
 // Send data out after a certain delay.
@@ -190,8 +231,12 @@ type Delay struct {
 func (g *Delay) Run() {
 	delay, _ := time.ParseDuration((<-g.Delay).(string))
 	for m := range g.In {
-		time.Sleep(delay)
-		g.Out.Send(m)
+		select {
+		case <-time.After(delay):
+			g.Out.Send(m)
+		case <-g.Done():
+			return
+		}
 	}
 }
 
@@ -287,7 +332,7 @@ func (g *EnvVar) Run() {
 // Turn command-line arguments into a message flow. Registers as "CmdLine".
 type CmdLine struct {
 	flow.Gadget
-	Type flow.Input
+	Type flow.Input `flow:"optional"`
 	Out  flow.Output
 }
 
@@ -335,9 +380,9 @@ func (g *CmdLine) Run() {
 // Registers as "Concat3".
 type Concat3 struct {
 	flow.Gadget
-	In1 flow.Input
-	In2 flow.Input
-	In3 flow.Input
+	In1 flow.Input `flow:"optional"`
+	In2 flow.Input `flow:"optional"`
+	In3 flow.Input `flow:"optional"`
 	Out flow.Output
 }
 
@@ -371,3 +416,81 @@ func (g *AddTag) Run() {
 		}
 	}
 }
+
+// look up the codec set on an optional pin, JSON is used if none was given
+func codecPin(in flow.Input) flow.Codec {
+	name := ""
+	if m, ok := <-in; ok {
+		name = m.(string)
+	}
+	codec, err := flow.LookupCodec(name)
+	flow.Check(err)
+	return codec
+}
+
+// WriteFileMessages saves all incoming messages to a file, encoded with the
+// codec set on Codec. Registers as "WriteFileMessages".
+type WriteFileMessages struct {
+	flow.Gadget
+	Name  flow.Input
+	Codec flow.Input `flow:"optional"`
+	In    flow.Input
+}
+
+// Start writing each message as a length-prefixed record.
+func (g *WriteFileMessages) Run() {
+	name := (<-g.Name).(string)
+	codec := codecPin(g.Codec)
+	file, err := os.Create(name)
+	flow.Check(err)
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	defer w.Flush()
+	for m := range g.In {
+		data, err := codec.Encode(m)
+		flow.Check(err)
+		var size [binary.MaxVarintLen64]byte
+		_, err = w.Write(size[:binary.PutUvarint(size[:], uint64(len(data)))])
+		flow.Check(err)
+		_, err = w.Write(data)
+		flow.Check(err)
+	}
+}
+
+// ReadFileMessages takes strings and replaces them by the messages saved in
+// that file by WriteFileMessages. Registers as "ReadFileMessages".
+type ReadFileMessages struct {
+	flow.Gadget
+	Codec flow.Input `flow:"optional"`
+	In    flow.Input
+	Out   flow.Output
+}
+
+// Start reading filenames and emit a <file> tag followed by the messages.
+func (g *ReadFileMessages) Run() {
+	codec := codecPin(g.Codec)
+	for m := range g.In {
+		if name, ok := m.(string); ok {
+			file, err := os.Open(name)
+			flow.Check(err)
+			g.Out.Send(flow.Tag{"<file>", name})
+			r := bufio.NewReader(file)
+			for {
+				size, err := binary.ReadUvarint(r)
+				if err == io.EOF {
+					break
+				}
+				flow.Check(err)
+				data := make([]byte, size)
+				_, err = io.ReadFull(r, data)
+				flow.Check(err)
+				msg, err := codec.Decode(data)
+				flow.Check(err)
+				g.Out.Send(msg)
+			}
+			file.Close()
+		} else {
+			g.Out.Send(m)
+		}
+	}
+}
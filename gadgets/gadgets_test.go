@@ -1,7 +1,9 @@
 package gadgets
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jcw/flow"
@@ -185,3 +187,45 @@ func ExampleAddTag() {
 	// Lost flow.Tag: {foo 1}
 	// Lost flow.Tag: {foo 3}
 }
+
+// strips the directory from <file> tags, to report them the same way each run
+type baseNames struct {
+	flow.Gadget
+	In  flow.Input
+	Out flow.Output
+}
+
+func (g *baseNames) Run() {
+	for m := range g.In {
+		if t, ok := m.(flow.Tag); ok && t.Tag == "<file>" {
+			m = flow.Tag{t.Tag, filepath.Base(t.Msg.(string))}
+		}
+		g.Out.Send(m)
+	}
+}
+
+func ExampleWriteFileMessages() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "messages.tmp")
+
+	g := flow.NewCircuit()
+	g.Add("w", "WriteFileMessages")
+	g.Feed("w.Name", name)
+	g.Feed("w.Codec", "msgpack")
+	g.Feed("w.In", 1)
+	g.Feed("w.In", flow.Tag{"a", []byte("abc")})
+	g.Run()
+
+	g = flow.NewCircuit()
+	g.Add("r", "ReadFileMessages")
+	g.AddCircuitry("b", new(baseNames))
+	g.Connect("r.Out", "b.In", 0)
+	g.Feed("r.Codec", "msgpack")
+	g.Feed("r.In", name)
+	g.Run()
+	// Output:
+	// Lost flow.Tag: {<file> messages.tmp}
+	// Lost int: 1
+	// Lost flow.Tag: {a [97 98 99]}
+}
@@ -7,6 +7,7 @@ import (
 
 func init() {
 	flow.Registry["Pipe"] = func() flow.Circuitry { return new(Pipe) }
+	flow.Help["Pipe"] = "Pass all incoming messages through unchanged."
 }
 
 
@@ -0,0 +1,163 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/golang/glog"
+	"github.com/jcw/flow"
+)
+
+func init() {
+	flow.Registry["Filter"] = func() flow.Circuitry { return new(Filter) }
+	flow.Registry["Map"] = func() flow.Circuitry { return new(Map) }
+	flow.Registry["Switch"] = func() flow.Circuitry { return new(Switch) }
+
+	flow.Help["Filter"] = "Pass on messages for which the expression is true."
+	flow.Help["Map"] = "Replace each message by the result of the expression."
+	flow.Help["Switch"] = "Send each message to the Out:name the expression gives."
+
+	for _, name := range []string{"Filter", "Map", "Switch"} {
+		flow.FeedChecks[name+".Expr"] = checkExprFeed
+	}
+}
+
+// An expression is a JavaScript expression, evaluated with the message as msg.
+type expression struct {
+	vm *goja.Runtime
+	fn goja.Callable
+}
+
+// CheckExpr reports whether an expression for Filter, Map, or Switch compiles.
+func CheckExpr(src string) error {
+	_, err := compileExpr(src)
+	return err
+}
+
+// check an expression fed to Expr, when definitions are validated or linted
+func checkExprFeed(m flow.Message) error {
+	src, ok := m.(string)
+	if !ok {
+		return fmt.Errorf("expression must be a string, not %T", m)
+	}
+	return CheckExpr(src)
+}
+
+func compileExpr(src string) (*expression, error) {
+	code := "(function(msg) { return (" + src + "\n) })"
+	prog, err := goja.Compile("expr", code, true)
+	if err != nil {
+		return nil, err
+	}
+	vm := goja.New()
+	v, err := vm.RunProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	fn, _ := goja.AssertFunction(v)
+	return &expression{vm, fn}, nil
+}
+
+// read and compile the expression set on a pin, before any messages flow
+func exprPin(pin flow.Input) (*expression, error) {
+	m, ok := <-pin
+	if !ok {
+		return nil, errors.New("no expression set")
+	}
+	if err := checkExprFeed(m); err != nil {
+		return nil, err
+	}
+	return compileExpr(m.(string))
+}
+
+func (e *expression) eval(m flow.Message) (goja.Value, error) {
+	return e.fn(goja.Undefined(), e.vm.ToValue(m))
+}
+
+// Filter passes on each message for which the expression set on Expr is true,
+// e.g. "msg.temp > 20", and sends all others to Rej. Registers as "Filter".
+type Filter struct {
+	flow.Gadget
+	Expr flow.Input
+	In   flow.Input
+	Out  flow.Output
+	Rej  flow.Output
+}
+
+// Start filtering, once the expression has been compiled.
+func (g *Filter) Run() {
+	e, err := exprPin(g.Expr)
+	if err != nil {
+		flow.DrainOnError(g.In, "filter:", err)
+		return
+	}
+	for m := range g.In {
+		v, err := e.eval(m)
+		if err != nil {
+			glog.Errorln("filter:", err)
+		}
+		if err == nil && v.ToBoolean() {
+			g.Out.Send(m)
+		} else {
+			g.Rej.Send(m)
+		}
+	}
+}
+
+// Map replaces each message by the result of the expression set on Expr, e.g.
+// `{"id": msg.node, "t": msg.temp}`. Messages for which the expression is
+// undefined are dropped. Registers as "Map".
+type Map struct {
+	flow.Gadget
+	Expr flow.Input
+	In   flow.Input
+	Out  flow.Output
+}
+
+// Start mapping, once the expression has been compiled.
+func (g *Map) Run() {
+	e, err := exprPin(g.Expr)
+	if err != nil {
+		flow.DrainOnError(g.In, "map:", err)
+		return
+	}
+	for m := range g.In {
+		v, err := e.eval(m)
+		if err != nil {
+			glog.Errorln("map:", err)
+		} else if !goja.IsUndefined(v) {
+			g.Out.Send(fromJS(v))
+		}
+	}
+}
+
+// Switch sends each message to the output named by the result of the
+// expression set on Expr, e.g. `msg.Tag` sends tag "a" to Out:a. Messages
+// without matching output are sent to Rej. Registers as "Switch".
+type Switch struct {
+	flow.Gadget
+	Expr flow.Input
+	In   flow.Input
+	Out  map[string]flow.Output
+	Rej  flow.Output
+}
+
+// Start switching, once the expression has been compiled.
+func (g *Switch) Run() {
+	e, err := exprPin(g.Expr)
+	if err != nil {
+		flow.DrainOnError(g.In, "switch:", err)
+		return
+	}
+	for m := range g.In {
+		v, err := e.eval(m)
+		if err != nil {
+			glog.Errorln("switch:", err)
+		} else if out, ok := g.Out[v.String()]; ok {
+			out.Send(m)
+			continue
+		}
+		g.Rej.Send(m)
+	}
+}
@@ -0,0 +1,98 @@
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcw/flow"
+)
+
+func ExampleFilter() {
+	g := flow.NewCircuit()
+	g.Add("f", "Filter")
+	g.Add("p", "Printer")
+	g.Add("r", "Printer")
+	g.Connect("f.Out", "p.In", 0)
+	g.Connect("f.Rej", "r.In", 0)
+	g.Feed("f.Expr", "msg.temp > 20")
+	g.Feed("f.In", map[string]interface{}{"temp": 18})
+	g.Feed("f.In", map[string]interface{}{"temp": 22})
+	g.Run()
+	// Unordered output:
+	// map[temp:22]
+	// map[temp:18]
+}
+
+func ExampleMap() {
+	g := flow.NewCircuit()
+	g.Add("m", "Map")
+	g.Feed("m.Expr", `{"id": msg.node, "t": msg.temp}`)
+	g.Feed("m.In", map[string]interface{}{"node": "n1", "temp": 21.5})
+	g.Feed("m.In", flow.Tag{"a", 1})
+	g.Run()
+	// Output:
+	// Lost map[string]interface {}: map[id:n1 t:21.5]
+	// Lost map[string]interface {}: map[id:<nil> t:<nil>]
+}
+
+func ExampleSwitch() {
+	g := flow.NewCircuit()
+	g.Add("s", "Switch")
+	g.Add("p", "Printer")
+	g.Connect("s.Out:a", "p.In", 0)
+	g.Connect("s.Rej", "p.In", 0)
+	g.Feed("s.Expr", "msg.Tag")
+	g.Feed("s.In", flow.Tag{"a", 1})
+	g.Feed("s.In", flow.Tag{"b", 2})
+	g.Run()
+	// Output:
+	// {Tag:a Msg:1}
+	// {Tag:b Msg:2}
+}
+
+func ExampleCheckExpr() {
+	fmt.Println(CheckExpr("msg.temp > 20"))
+	fmt.Println(CheckExpr("msg.temp >") != nil)
+	// Output:
+	// <nil>
+	// true
+}
+
+func ExampleMap_badExpr() {
+	g := flow.NewCircuit()
+	g.Add("m", "Map")
+	g.Add("c", "Counter")
+	g.Connect("m.Out", "c.In", 0)
+	g.Feed("m.Expr", "msg.temp >")
+	g.Feed("m.In", 1)
+	g.Run()
+	// Output:
+	// Lost int: 0
+}
+
+func ExampleCheckExpr_lint() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(name, []byte(`{
+		"Hot": {
+			"gadgets": [ { "name": "f", "type": "Filter" } ],
+			"feeds": [ { "to": "f.Expr", "data": "msg.temp >" } ],
+			"labels": [ { "external": "In", "internal": "f.In" },
+			            { "external": "Out", "internal": "f.Out" },
+			            { "external": "Rej", "internal": "f.Rej" } ]
+		}
+	}`), 0666)
+	issues, _ := flow.Lint(flow.Registry, name)
+	for _, issue := range issues {
+		fmt.Println(issue.Line, issue.Severity,
+			strings.HasPrefix(issue.Message, "bad feed for f.Expr: SyntaxError"))
+	}
+	fmt.Println(flow.ReloadRegistry(name) != nil, flow.Registry["Hot"] == nil)
+	// Output:
+	// 4 error true
+	// true true
+}
@@ -0,0 +1,117 @@
+// Scripted gadgets, using an embedded JavaScript interpreter.
+package script
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/golang/glog"
+	"github.com/jcw/flow"
+)
+
+func init() {
+	flow.Registry["Script"] = func() flow.Circuitry { return new(Script) }
+	flow.Help["Script"] = "Run the JavaScript set on Src for each message."
+}
+
+// Register adds a script to the registry, as a Script gadget with the given
+// source already set.
+func Register(name, src string) {
+	flow.Registry[name] = func() flow.Circuitry { return &Script{src: src} }
+	flow.Help[name] = "Run a registered script for each message."
+}
+
+// Script runs JavaScript code for each incoming message. The source is set on
+// Src, either as code or as the name of a ".js" file. It is run once at the
+// start, and must define an onMessage(msg) function, which is then called for
+// each message. If it returns a value, that value is sent to Out. The script
+// can also call emit(name, msg) to send messages to Emit:name, and tag(tag,
+// msg) to create a tag. Global variables keep their values between messages.
+// If the script defines onEnd(), it is called once all input has been
+// processed, and its result is also sent to Out. If the script can't be loaded
+// or has no onMessage, the error is logged and all input is dropped. Registers
+// as "Script".
+type Script struct {
+	flow.Gadget
+	Src  flow.Input `flow:"optional"`
+	In   flow.Input
+	Out  flow.Output
+	Emit map[string]flow.Output
+
+	src string
+}
+
+// Start running the script, once its source is known.
+func (g *Script) Run() {
+	if m, ok := <-g.Src; ok {
+		g.src, _ = m.(string)
+	}
+	if strings.HasSuffix(g.src, ".js") && !strings.ContainsAny(g.src, "\n;") {
+		data, err := ioutil.ReadFile(g.src)
+		if err != nil {
+			flow.DrainOnError(g.In, "Script:", err)
+			return
+		}
+		g.src = string(data)
+	}
+
+	vm := goja.New()
+	vm.Set("emit", func(name string, m goja.Value) {
+		if out, ok := g.Emit[name]; ok {
+			out.Send(fromJS(m))
+		} else {
+			glog.Warningln("script output not connected:", name)
+		}
+	})
+	vm.Set("tag", func(tag string, m goja.Value) flow.Tag {
+		return flow.Tag{Tag: tag, Msg: fromJS(m)}
+	})
+	if _, err := vm.RunString(g.src); err != nil {
+		flow.DrainOnError(g.In, "Script:", err)
+		return
+	}
+	onMessage, ok := goja.AssertFunction(vm.Get("onMessage"))
+	if !ok {
+		flow.DrainOnError(g.In, "Script: no onMessage defined")
+		return
+	}
+	call := func(fn goja.Callable, args ...goja.Value) {
+		v, err := fn(goja.Undefined(), args...)
+		if err != nil {
+			glog.Errorln("script:", err)
+		} else if !goja.IsUndefined(v) && !goja.IsNull(v) {
+			g.Out.Send(fromJS(v))
+		}
+	}
+
+	for m := range g.In {
+		call(onMessage, vm.ToValue(m))
+	}
+	if onEnd, ok := goja.AssertFunction(vm.Get("onEnd")); ok {
+		call(onEnd)
+	}
+}
+
+// convert a JavaScript value to a message, with integers as int
+func fromJS(v goja.Value) flow.Message {
+	return fixInts(v.Export())
+}
+
+func fixInts(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int64:
+		if int64(int(x)) == x {
+			return int(x)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = fixInts(e)
+		}
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = fixInts(e)
+		}
+	}
+	return v
+}
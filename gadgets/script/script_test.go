@@ -0,0 +1,85 @@
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleScript() {
+	g := flow.NewCircuit()
+	g.Add("s", "Script")
+	g.Add("p", "Printer")
+	g.Connect("s.Out", "p.In", 0)
+	g.Connect("s.Emit:big", "p.In", 0)
+	g.Feed("s.Src", `
+		var total = 0;
+		function onMessage(msg) {
+			total += msg.n;
+			if (msg.n > 10) emit("big", msg);
+			return tag("total", total);
+		}
+		function onEnd() { return "done"; }
+	`)
+	g.Feed("s.In", map[string]interface{}{"n": 3})
+	g.Feed("s.In", map[string]interface{}{"n": 20})
+	g.Run()
+	// Output:
+	// {Tag:total Msg:3}
+	// map[n:20]
+	// {Tag:total Msg:23}
+	// done
+}
+
+func ExampleRegister() {
+	Register("Double", `function onMessage(m) { return m * 2 }`)
+
+	g := flow.NewCircuit()
+	g.Add("d", "Double")
+	g.Feed("d.In", 21)
+	g.Run()
+	fmt.Println(flow.Help["Double"])
+	// Output:
+	// Lost int: 42
+	// Run a registered script for each message.
+}
+
+func ExampleScript_badSource() {
+	g := flow.NewCircuit()
+	g.Add("s", "Script")
+	g.Add("c", "Counter")
+	g.Connect("s.Out", "c.In", 0)
+	g.Feed("s.Src", `function onMsg(m) { return m }`)
+	g.Feed("s.In", 1)
+	g.Feed("s.In", 2)
+	g.Run()
+	// Output:
+	// Lost int: 0
+}
+
+func ExampleScript_definition() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(name, []byte(`{
+		"Upper": {
+			"gadgets": [ { "name": "s", "type": "Script" } ],
+			"feeds": [ { "to": "s.Src",
+				"data": "function onMessage(m) { return m.toUpperCase() }" } ],
+			"labels": [ { "external": "In", "internal": "s.In" },
+			            { "external": "Out", "internal": "s.Out" } ]
+		}
+	}`), 0666)
+	flow.Check(flow.AddToRegistry(name))
+
+	g := flow.NewCircuit()
+	g.Add("u", "Upper")
+	g.Feed("u.In", "hello")
+	g.Run()
+	// Output:
+	// Lost string: HELLO
+}
@@ -0,0 +1,213 @@
+// Sandboxed gadgets, running WebAssembly modules.
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcw/flow"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func init() {
+	flow.Registry["Wasm"] = func() flow.Circuitry { return new(Wasm) }
+	flow.Help["Wasm"] = "Pass each message to the WebAssembly module set on Module."
+}
+
+// Default limits for each module, can be changed through the Memory and
+// Timeout pins.
+const (
+	defaultMemory  = 16 << 20
+	defaultTimeout = time.Second
+)
+
+// Register adds a WebAssembly module file to the registry, as a Wasm gadget
+// with the module already set.
+func Register(name, path string) {
+	flow.Registry[name] = func() flow.Circuitry { return &Wasm{path: path} }
+	flow.Help[name] = "Pass each message to a registered WebAssembly module."
+}
+
+// Wasm passes each incoming message to a WebAssembly module, which runs in a
+// sandbox without access to anything outside it. The module is set on Module,
+// as the name of a ".wasm" file or as its contents. It must export:
+//
+//	memory                     its linear memory
+//	alloc(size i32) i32        returns room for an incoming message
+//	handle(ptr i32, size i32)  processes one message
+//
+// The module can import this function from "flow", to send out messages:
+//
+//	emit(pin i32, pinSize i32, ptr i32, size i32)
+//
+// Messages are exchanged encoded with the codec set on Codec, JSON by default.
+// An empty pin name sends to Out, all others to Emit:name. Emitted messages
+// are sent out once handle returns. Each module gets at most the number of
+// bytes set on Memory (default 16 MB), and each message at most the time set
+// on Timeout (default 1s), including the time to start the module. A message
+// which fails or takes too long is dropped, and the module is then started
+// afresh. If the module or settings are not usable, the error is logged and
+// all input is dropped. Registers as "Wasm".
+type Wasm struct {
+	flow.Gadget
+	Module  flow.Input `flow:"optional"`
+	Codec   flow.Input `flow:"optional"`
+	Memory  flow.Input `flow:"optional"`
+	Timeout flow.Input `flow:"optional"`
+	In      flow.Input
+	Out     flow.Output
+	Emit    map[string]flow.Output
+
+	path string
+}
+
+// an emitted message, held back until the call to handle returns
+type emitted struct {
+	pin string
+	msg flow.Message
+}
+
+// Start handling messages, once the module has been loaded.
+func (g *Wasm) Run() {
+	var code []byte
+	switch m := (<-g.Module).(type) {
+	case []byte:
+		code = m
+	case string:
+		g.path = m
+	}
+	if code == nil {
+		var err error
+		if code, err = ioutil.ReadFile(g.path); err != nil {
+			flow.DrainOnError(g.In, "wasm:", err)
+			return
+		}
+	}
+	codecName := ""
+	if m, ok := <-g.Codec; ok {
+		codecName, _ = m.(string)
+	}
+	codec, err := flow.LookupCodec(codecName)
+	if err != nil {
+		flow.DrainOnError(g.In, "wasm:", err)
+		return
+	}
+	memory := defaultMemory
+	if m, ok := <-g.Memory; ok {
+		if memory, _ = flow.IntValue(m); memory <= 0 {
+			flow.DrainOnError(g.In, "wasm: memory must be a positive number:", m)
+			return
+		}
+	}
+	timeout := defaultTimeout
+	if m, ok := <-g.Timeout; ok {
+		s, _ := m.(string)
+		if timeout, err = time.ParseDuration(s); err != nil {
+			flow.DrainOnError(g.In, "wasm: bad timeout:", m)
+			return
+		}
+	}
+
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32((memory + 65535) >> 16)).
+		WithCloseOnContextDone(true)
+	rt := wazero.NewRuntimeWithConfig(ctx, config)
+	defer rt.Close(ctx)
+
+	var pending []emitted
+	var emitErr error
+	emit := func(ctx context.Context, mod api.Module, pin, pinSize, ptr, size uint32) {
+		name, ok1 := mod.Memory().Read(pin, pinSize)
+		data, ok2 := mod.Memory().Read(ptr, size)
+		if !ok1 || !ok2 {
+			emitErr = fmt.Errorf("emit out of range")
+			return
+		}
+		m, err := codec.Decode(data)
+		if err != nil {
+			emitErr = err
+			return
+		}
+		pending = append(pending, emitted{string(name), m})
+	}
+	_, err = rt.NewHostModuleBuilder("flow").
+		NewFunctionBuilder().WithFunc(emit).Export("emit").
+		Instantiate(ctx)
+	if err != nil {
+		flow.DrainOnError(g.In, "wasm:", err)
+		return
+	}
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		flow.DrainOnError(g.In, "wasm:", err)
+		return
+	}
+
+	var mod api.Module
+	defer func() {
+		if mod != nil {
+			mod.Close(ctx)
+		}
+	}()
+	for m := range g.In {
+		pending, emitErr = nil, nil
+		// the time limit also covers starting a fresh instance of the module
+		msgCtx, cancel := context.WithTimeout(ctx, timeout)
+		var err error
+		if mod == nil {
+			mod, err = rt.InstantiateModule(msgCtx, compiled,
+				wazero.NewModuleConfig().WithName(""))
+		}
+		if err == nil {
+			err = g.handle(msgCtx, mod, codec, m)
+		}
+		cancel()
+		if err == nil {
+			err = emitErr
+		}
+		if err != nil {
+			glog.Errorln("wasm:", err)
+			if mod != nil {
+				mod.Close(ctx)
+				mod = nil // start afresh for the next message
+			}
+			continue
+		}
+		for _, e := range pending {
+			if e.pin == "" {
+				g.Out.Send(e.msg)
+			} else if out, ok := g.Emit[e.pin]; ok {
+				out.Send(e.msg)
+			} else {
+				glog.Warningln("wasm: output not connected:", e.pin)
+			}
+		}
+	}
+}
+
+// pass one message to the module, the context holds the time limit
+func (g *Wasm) handle(ctx context.Context, mod api.Module, codec flow.Codec, m flow.Message) error {
+	data, err := codec.Encode(m)
+	if err != nil {
+		return err
+	}
+	alloc, handle := mod.ExportedFunction("alloc"), mod.ExportedFunction("handle")
+	if alloc == nil || handle == nil || mod.Memory() == nil {
+		return fmt.Errorf("module must export memory, alloc, and handle")
+	}
+	res, err := alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, data) {
+		return fmt.Errorf("alloc returned out of range: %d", ptr)
+	}
+	_, err = handle.Call(ctx, uint64(ptr), uint64(len(data)))
+	return err
+}
@@ -0,0 +1,101 @@
+package wasm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+)
+
+// a module which emits each message it gets on Out, as is:
+//
+//	(module
+//	  (import "flow" "emit" (func $emit (param i32 i32 i32 i32)))
+//	  (memory (export "memory") 1)
+//	  (func (export "alloc") (param i32) (result i32) i32.const 1024)
+//	  (func (export "handle") (param i32 i32)
+//	    (call $emit (i32.const 0) (i32.const 0) (local.get 0) (local.get 1))))
+var echoModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x12, 0x03, 0x60,
+	0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60,
+	0x02, 0x7f, 0x7f, 0x00, 0x02, 0x0d, 0x01, 0x04, 0x66, 0x6c, 0x6f, 0x77,
+	0x04, 0x65, 0x6d, 0x69, 0x74, 0x00, 0x00, 0x03, 0x03, 0x02, 0x01, 0x02,
+	0x05, 0x03, 0x01, 0x00, 0x01, 0x07, 0x1b, 0x03, 0x06, 0x6d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x00,
+	0x01, 0x06, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x00, 0x02, 0x0a, 0x14,
+	0x02, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x0c, 0x00, 0x41, 0x00, 0x41,
+	0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x0b,
+}
+
+// the same, but handle loops forever on messages of one byte, such as 1 in
+// JSON, i.e. it starts with:
+//
+//	(if (i32.eq (local.get 1) (i32.const 1)) (then (loop (br 0))))
+var loopModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x12, 0x03, 0x60,
+	0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60,
+	0x02, 0x7f, 0x7f, 0x00, 0x02, 0x0d, 0x01, 0x04, 0x66, 0x6c, 0x6f, 0x77,
+	0x04, 0x65, 0x6d, 0x69, 0x74, 0x00, 0x00, 0x03, 0x03, 0x02, 0x01, 0x02,
+	0x05, 0x03, 0x01, 0x00, 0x01, 0x07, 0x1b, 0x03, 0x06, 0x6d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x00,
+	0x01, 0x06, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x00, 0x02, 0x0a, 0x21,
+	0x02, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x19, 0x00, 0x20, 0x01, 0x41,
+	0x01, 0x46, 0x04, 0x40, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b, 0x41, 0x00,
+	0x41, 0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x0b,
+}
+
+func ExampleWasm() {
+	g := flow.NewCircuit()
+	g.Add("w", "Wasm")
+	g.Feed("w.Module", echoModule)
+	g.Feed("w.In", "abc")
+	g.Feed("w.In", flow.Tag{"a", []interface{}{1, "b"}})
+	g.Run()
+	// Output:
+	// Lost string: abc
+	// Lost flow.Tag: {a [1 b]}
+}
+
+func ExampleWasm_timeout() {
+	g := flow.NewCircuit()
+	g.Add("w", "Wasm")
+	g.Feed("w.Module", loopModule)
+	g.Feed("w.Timeout", "10ms")
+	g.Feed("w.In", 1)
+	g.Feed("w.In", 2)
+	g.Feed("w.In", "abc")
+	g.Run()
+	// Output:
+	// Lost string: abc
+}
+
+func ExampleRegister() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "echo.wasm")
+	ioutil.WriteFile(name, echoModule, 0666)
+	Register("Echo", name)
+
+	g := flow.NewCircuit()
+	g.Add("e", "Echo")
+	g.Feed("e.Codec", "msgpack")
+	g.Feed("e.In", 123)
+	g.Run()
+	// Output:
+	// Lost int: 123
+}
+
+func ExampleWasm_settings() {
+	g := flow.NewCircuit()
+	g.Add("w", "Wasm")
+	g.Feed("w.Module", echoModule)
+	g.Feed("w.Memory", 65536.0) // as decoded from JSON
+	g.Feed("w.In", "abc")
+	g.Add("b", "Wasm")
+	g.Feed("b.Module", []byte("not a module"))
+	g.Feed("b.In", "dropped")
+	g.Run()
+	// Output:
+	// Lost string: abc
+}
@@ -0,0 +1,245 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of nodes in a graph rendering of a circuit.
+const (
+	graphGadget = iota
+	graphPort
+	graphFeed
+)
+
+// circuit layout, independent of the output format
+type graphCluster struct {
+	id, label string
+	nodes     []graphNode
+	clusters  []*graphCluster
+}
+
+type graphNode struct {
+	id, label string
+	kind      int
+}
+
+type graphEdge struct {
+	from, to   string
+	tail, head string // pin names at each end
+	capacity   int
+}
+
+// collect the nodes and edges of a circuit, recursing into nested circuits
+func (c *Circuit) layout(id, label string, edges *[]graphEdge) *graphCluster {
+	cl := &graphCluster{id: id, label: label}
+	prefix := ""
+	if id != "" {
+		prefix = id + "/"
+	}
+	types := map[string]string{}
+	for _, g := range c.gnames {
+		types[g.Name] = g.Type
+	}
+
+	// refer to a pin, which is a port node if it's on a nested circuit
+	endpoint := func(pin string) (string, string) {
+		name := gadgetPart(pin)
+		if g := c.gadgets[name]; g != nil {
+			if _, ok := g.circuitry.(*Circuit); ok {
+				return prefix + name + "/@" + strings.Split(pinPart(pin), ":")[0],
+					""
+			}
+		}
+		return prefix + name, pinPart(pin)
+	}
+
+	for _, name := range sortedKeys(c.gadgets) {
+		g := c.gadgets[name]
+		typ := types[name]
+		if sub, ok := g.circuitry.(*Circuit); ok {
+			if typ != "" {
+				name += " (" + typ + ")"
+			}
+			cl.clusters = append(cl.clusters,
+				sub.layout(prefix+g.name, name, edges))
+			continue
+		}
+		if typ == "" {
+			typ = fmt.Sprintf("%T", g.circuitry)
+		}
+		cl.nodes = append(cl.nodes,
+			graphNode{prefix + name, name + "\n" + typ, graphGadget})
+	}
+
+	for _, ext := range sortedKeys(c.labels) {
+		port := prefix + "@" + ext
+		cl.nodes = append(cl.nodes, graphNode{port, ext, graphPort})
+		id, pin := endpoint(c.labels[ext])
+		internal := c.labels[ext]
+		if pinIsOutput(c.gadgets[gadgetPart(internal)], pinPart(internal)) {
+			*edges = append(*edges, graphEdge{from: id, to: port, tail: pin})
+		} else {
+			*edges = append(*edges, graphEdge{from: port, to: id, head: pin})
+		}
+	}
+
+	n := 0
+	for _, pin := range sortedKeys(c.feeds) {
+		for _, m := range c.feeds[pin] {
+			n++
+			feed := fmt.Sprintf("%s#feed%d", prefix, n)
+			text := fmt.Sprintf("%v", m)
+			if t, ok := m.(Tag); ok {
+				text = fmt.Sprintf("%s: %v", t.Tag, t.Msg)
+			}
+			cl.nodes = append(cl.nodes, graphNode{feed, text, graphFeed})
+			id, head := endpoint(pin)
+			*edges = append(*edges, graphEdge{from: feed, to: id, head: head})
+		}
+	}
+
+	for _, w := range c.wires {
+		from, tail := endpoint(w.From)
+		to, head := endpoint(w.To)
+		*edges = append(*edges, graphEdge{from, to, tail, head, w.Capacity})
+	}
+	return cl
+}
+
+// WriteDot renders the circuit in Graphviz DOT format. Nested circuits are
+// shown as clusters, labeled pins as ports on their boundary, feeds as notes,
+// and wire capacities as edge labels.
+func (c *Circuit) WriteDot(w io.Writer) error {
+	var edges []graphEdge
+	top := c.layout("", "", &edges)
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph circuit {\n")
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box];\n")
+	var cluster func(cl *graphCluster, indent string)
+	cluster = func(cl *graphCluster, indent string) {
+		for _, n := range cl.nodes {
+			attrs := ""
+			switch n.kind {
+			case graphPort:
+				attrs = ", shape=circle"
+			case graphFeed:
+				attrs = ", shape=note"
+			}
+			fmt.Fprintf(&buf, "%s%s [label=%s%s];\n", indent, dotQuote(n.id),
+				dotQuote(n.label), attrs)
+		}
+		for _, sub := range cl.clusters {
+			fmt.Fprintf(&buf, "%ssubgraph %s {\n", indent,
+				dotQuote("cluster_"+sub.id))
+			fmt.Fprintf(&buf, "%s  label=%s;\n", indent, dotQuote(sub.label))
+			cluster(sub, indent+"  ")
+			fmt.Fprintf(&buf, "%s}\n", indent)
+		}
+	}
+	cluster(top, "  ")
+	for _, e := range edges {
+		attrs := []string{}
+		if e.tail != "" {
+			attrs = append(attrs, "taillabel="+dotQuote(e.tail))
+		}
+		if e.head != "" {
+			attrs = append(attrs, "headlabel="+dotQuote(e.head))
+		}
+		if e.capacity != 0 {
+			attrs = append(attrs, fmt.Sprintf("label=\"%d\"", e.capacity))
+		}
+		fmt.Fprintf(&buf, "  %s -> %s", dotQuote(e.from), dotQuote(e.to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&buf, " [%s]", strings.Join(attrs, ", "))
+		}
+		buf.WriteString(";\n")
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + strings.Replace(s, "\n", `\n`, -1) + `"`
+}
+
+// WriteMermaid renders the circuit as a Mermaid flowchart, using the same
+// conventions as WriteDot.
+func (c *Circuit) WriteMermaid(w io.Writer) error {
+	var edges []graphEdge
+	top := c.layout("", "", &edges)
+
+	var buf bytes.Buffer
+	buf.WriteString("flowchart LR\n")
+	var cluster func(cl *graphCluster, indent string)
+	cluster = func(cl *graphCluster, indent string) {
+		for _, n := range cl.nodes {
+			open, close := "[", "]"
+			switch n.kind {
+			case graphPort:
+				open, close = "((", "))"
+			case graphFeed:
+				open, close = ">", "]"
+			}
+			fmt.Fprintf(&buf, "%s%s%s%s%s\n", indent, mermaidID(n.id), open,
+				mermaidQuote(n.label), close)
+		}
+		for _, sub := range cl.clusters {
+			fmt.Fprintf(&buf, "%ssubgraph %s[%s]\n", indent, mermaidID(sub.id),
+				mermaidQuote(sub.label))
+			cluster(sub, indent+"  ")
+			fmt.Fprintf(&buf, "%send\n", indent)
+		}
+	}
+	cluster(top, "  ")
+	for _, e := range edges {
+		text := e.tail
+		if e.head != "" {
+			if text != "" {
+				text += " → "
+			}
+			text += e.head
+		}
+		if e.capacity != 0 {
+			text += fmt.Sprintf(" (%d)", e.capacity)
+		}
+		arrow := "-->"
+		if text != "" {
+			arrow = "-- " + mermaidQuote(strings.TrimSpace(text)) + " -->"
+		}
+		fmt.Fprintf(&buf, "  %s %s %s\n", mermaidID(e.from), arrow,
+			mermaidID(e.to))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// node ids are escaped unambiguously: "_" is doubled and all other characters
+// which are not letters or digits are written as "_" plus two hex digits
+func mermaidID(s string) string {
+	var buf bytes.Buffer
+	buf.WriteString("n_")
+	for _, b := range []byte(s) {
+		switch {
+		case b == '_':
+			buf.WriteString("__")
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "_%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func mermaidQuote(s string) string {
+	s = strings.Replace(s, `"`, "#quot;", -1)
+	return `"` + strings.Replace(s, "\n", "<br/>", -1) + `"`
+}
@@ -0,0 +1,76 @@
+package flow_test
+
+import (
+	"os"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func graphCircuit() *flow.Circuit {
+	sub := flow.NewCircuit()
+	sub.Add("r", "Repeater")
+	sub.Feed("r.Num", 3)
+	sub.Label("In", "r.In")
+	sub.Label("Out", "r.Out")
+
+	g := flow.NewCircuit()
+	g.AddCircuitry("sub", sub)
+	g.Add("f", "FanOut")
+	g.Add("c", "Counter")
+	g.Connect("sub.Out", "f.In", 2)
+	g.Connect("f.Out:x", "c.In", 0)
+	g.Feed("sub.In", "abc")
+	g.Label("Count", "c.Out")
+	return g
+}
+
+func ExampleCircuit_WriteDot() {
+	graphCircuit().WriteDot(os.Stdout)
+	// Output:
+	// digraph circuit {
+	//   rankdir=LR;
+	//   node [shape=box];
+	//   "c" [label="c\nCounter"];
+	//   "f" [label="f\nFanOut"];
+	//   "@Count" [label="Count", shape=circle];
+	//   "#feed1" [label="abc", shape=note];
+	//   subgraph "cluster_sub" {
+	//     label="sub";
+	//     "sub/r" [label="r\nRepeater"];
+	//     "sub/@In" [label="In", shape=circle];
+	//     "sub/@Out" [label="Out", shape=circle];
+	//     "sub/#feed1" [label="3", shape=note];
+	//   }
+	//   "sub/@In" -> "sub/r" [headlabel="In"];
+	//   "sub/r" -> "sub/@Out" [taillabel="Out"];
+	//   "sub/#feed1" -> "sub/r" [headlabel="Num"];
+	//   "c" -> "@Count" [taillabel="Out"];
+	//   "#feed1" -> "sub/@In";
+	//   "sub/@Out" -> "f" [headlabel="In", label="2"];
+	//   "f" -> "c" [taillabel="Out:x", headlabel="In"];
+	// }
+}
+
+func ExampleCircuit_WriteMermaid() {
+	graphCircuit().WriteMermaid(os.Stdout)
+	// Output:
+	// flowchart LR
+	//   n_c["c<br/>Counter"]
+	//   n_f["f<br/>FanOut"]
+	//   n__40Count(("Count"))
+	//   n__23feed1>"abc"]
+	//   subgraph n_sub["sub"]
+	//     n_sub_2Fr["r<br/>Repeater"]
+	//     n_sub_2F_40In(("In"))
+	//     n_sub_2F_40Out(("Out"))
+	//     n_sub_2F_23feed1>"3"]
+	//   end
+	//   n_sub_2F_40In -- "In" --> n_sub_2Fr
+	//   n_sub_2Fr -- "Out" --> n_sub_2F_40Out
+	//   n_sub_2F_23feed1 -- "Num" --> n_sub_2Fr
+	//   n_c -- "Out" --> n__40Count
+	//   n__23feed1 --> n_sub_2F_40In
+	//   n_sub_2F_40Out -- "In (2)" --> n_f
+	//   n_f -- "Out:x → In" --> n_c
+}
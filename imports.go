@@ -0,0 +1,188 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// one entry in the "imports" list of a definitions file, either a plain path
+// or pattern, or an object which also specifies a namespace
+type importDef struct {
+	Path string `json:"path"`
+	As   string `json:"as,omitempty"`
+}
+
+func (d *importDef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &d.Path); err == nil {
+		return nil
+	}
+	type plain importDef // avoid recursing into this method
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// definitions collected from a file and everything it imports
+type defSet struct {
+	defs   map[string]json.RawMessage
+	origin map[string]string // "file:line" where each definition came from
+}
+
+// read a JSON file with named circuit definitions, including the definitions
+// of all the files it imports
+func readDefinitions(filename string) (map[string]json.RawMessage, error) {
+	set, err := loadDefinitions(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	return set.defs, nil
+}
+
+// report definitions which another definitions file already registered in a
+// different way, this must be called with the registryMutex held
+func (set *defSet) checkRegistered(filename string) error {
+	own := map[string]bool{} // names a reload of the same file may redefine
+	for _, name := range defFiles[filename] {
+		own[name] = true
+	}
+	for _, name := range sortedKeys(set.defs) {
+		prev, ok := defOrigins[name]
+		if ok && !own[name] && !bytes.Equal(circuitDefs[name], set.defs[name]) {
+			return fmt.Errorf("duplicate circuit %s: %s and %s", name, prev,
+				set.origin[name])
+		}
+	}
+	return nil
+}
+
+// load a definitions file, the stack lists the files currently being imported
+func loadDefinitions(filename string, stack []string) (*defSet, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range stack {
+		if f == path {
+			cycle := append(stack[i:], path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	stack = append(stack, path)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var definitions map[string]json.RawMessage
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	positions, err := scanPositions(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	set := &defSet{
+		defs:   map[string]json.RawMessage{},
+		origin: map[string]string{},
+	}
+	if raw, ok := definitions["imports"]; ok {
+		delete(definitions, "imports")
+		var imports []importDef
+		if err := json.Unmarshal(raw, &imports); err != nil {
+			return nil, fmt.Errorf("%s: imports: %s", filename, err)
+		}
+		for _, imp := range imports {
+			pattern := imp.Path
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(filename), pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", filename, err)
+			}
+			if len(matches) == 0 && !strings.ContainsAny(imp.Path, "*?[") {
+				return nil, fmt.Errorf("%s: import not found: %s",
+					filename, imp.Path)
+			}
+			for _, match := range matches {
+				sub, err := loadDefinitions(match, stack)
+				if err != nil {
+					return nil, err
+				}
+				if imp.As != "" {
+					sub = sub.namespace(imp.As + ".")
+				}
+				if err := set.merge(sub); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	own := &defSet{
+		defs:   map[string]json.RawMessage{},
+		origin: map[string]string{},
+	}
+	for name, def := range definitions {
+		own.defs[name] = def
+		own.origin[name] = fmt.Sprintf("%s:%d", filename,
+			lineOf(data, positions[name].circuit))
+	}
+	return set, set.merge(own)
+}
+
+// add all definitions of another set, reporting duplicate names - the same
+// definition imported along different paths is not considered a duplicate
+func (set *defSet) merge(other *defSet) error {
+	for _, name := range sortedKeys(other.defs) {
+		if prev, ok := set.origin[name]; ok && prev != other.origin[name] {
+			return fmt.Errorf("duplicate circuit %s: %s and %s", name, prev,
+				other.origin[name])
+		}
+		set.defs[name] = other.defs[name]
+		set.origin[name] = other.origin[name]
+	}
+	return nil
+}
+
+// prefix all names in the set, including references to them as gadget type
+func (set *defSet) namespace(prefix string) *defSet {
+	result := &defSet{
+		defs:   map[string]json.RawMessage{},
+		origin: map[string]string{},
+	}
+	for name, def := range set.defs {
+		var circuit map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(def))
+		dec.UseNumber() // re-encode numbers exactly as they were written
+		if dec.Decode(&circuit) == nil {
+			for key, value := range circuit {
+				gadgets, _ := value.([]interface{})
+				if !strings.EqualFold(key, "gadgets") {
+					continue
+				}
+				for _, g := range gadgets {
+					g, _ := g.(map[string]interface{})
+					for k, v := range g {
+						typ, _ := v.(string)
+						if strings.EqualFold(k, "type") && set.defs[typ] != nil {
+							g[k] = prefix + typ
+						}
+					}
+				}
+			}
+			def, _ = json.Marshal(circuit)
+		}
+		result.defs[prefix+name] = def
+		result.origin[prefix+name] = set.origin[name]
+	}
+	return result
+}
+
+// line number of a byte offset in some text
+func lineOf(data []byte, offset int) int {
+	return 1 + strings.Count(string(data[:offset]), "\n")
+}
@@ -0,0 +1,74 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleAddToRegistry_imports() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "lib"), 0777)
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(text), 0666)
+		return path
+	}
+
+	write("lib/hello.json", `{
+		"Hello": {
+			"gadgets": [{ "name": "p", "type": "Printer" }],
+			"feeds": [{ "data": "hello", "to": "p.In" }]
+		},
+		"Nothing": { "gadgets": null }
+	}`)
+	write("lib/twice.json", `{
+		"imports": ["hello.json"],
+		"Twice": {
+			"gadgets": [
+				{ "name": "a", "type": "Hello" },
+				{ "name": "b", "type": "Hello" }
+			]
+		}
+	}`)
+	main := write("main.json", `{
+		"imports": [{ "path": "lib/*.json", "as": "lib" }],
+		"main": {
+			"gadgets": [{ "name": "t", "type": "lib.Twice" }]
+		}
+	}`)
+	fmt.Println(flow.AddToRegistry(main))
+	flow.Registry["main"]().(*flow.Circuit).Run()
+
+	// a definition may only be provided once
+	dup := write("dup.json", `{
+		"imports": ["lib/hello.json"],
+		"Hello": {}
+	}`)
+	err := flow.AddToRegistry(dup)
+	fmt.Println(strings.Replace(err.Error(), dir+"/", "", -1))
+
+	// nor redefined in another file
+	other := write("other.json", `{ "main": { "gadgets": null } }`)
+	err = flow.AddToRegistry(other)
+	fmt.Println(strings.Replace(err.Error(), dir+"/", "", -1))
+
+	// files must not import each other
+	write("a.json", `{ "imports": ["b.json"] }`)
+	write("b.json", `{ "imports": ["a.json"] }`)
+	err = flow.AddToRegistry(filepath.Join(dir, "a.json"))
+	fmt.Println(strings.Replace(err.Error(), dir+"/", "", -1))
+	// Output:
+	// <nil>
+	// hello
+	// hello
+	// duplicate circuit Hello: lib/hello.json:2 and dup.json:3
+	// duplicate circuit main: main.json:3 and other.json:1
+	// import cycle: a.json -> b.json -> a.json
+}
@@ -0,0 +1,218 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Stop a running circuit, including all nested circuits. All inputs are
+// closed and messages sent from then on are dropped, so gadgets end once they
+// have dealt with what was already queued. Gadgets which wait for anything
+// else should also select on Done. Run returns once all gadgets have ended.
+func (c *Circuit) Stop() {
+	c.stop.Do(func() {
+		atomic.StoreInt32(&c.dead, 1)
+		close(c.done)
+	})
+	c.lock.RLock()
+	gadgets := make([]*Gadget, 0, len(c.gadgets))
+	for _, g := range c.gadgets {
+		gadgets = append(gadgets, g)
+	}
+	c.lock.RUnlock()
+	for _, g := range gadgets {
+		if sub, ok := g.circuitry.(*Circuit); ok {
+			sub.Stop()
+		} else {
+			g.closeInputs()
+		}
+	}
+}
+
+// Done returns a channel which is closed when the circuit is stopped.
+func (c *Circuit) Done() <-chan struct{} {
+	return c.done
+}
+
+// Done returns a channel which is closed when the circuit is stopped, for
+// gadgets which sleep or wait for something other than their inputs.
+func (g *Gadget) Done() <-chan struct{} {
+	return g.owner.done
+}
+
+// close all inputs which are still open, no matter how many senders remain,
+// sends which are blocked give up once the circuit has been stopped
+func (g *Gadget) closeInputs() {
+	g.mutex.Lock() // the inputs may still be set up by launch
+	inputs := make([]*wire, 0, len(g.inputs))
+	for _, w := range g.inputs {
+		inputs = append(inputs, w)
+	}
+	g.mutex.Unlock()
+	for _, w := range inputs {
+		w.mutex.Lock()
+		w.senders = 0
+		w.closeChannel()
+		w.mutex.Unlock()
+	}
+}
+
+// find the wire of an input pin, given as "gadget.Pin" or as a path through
+// nested circuits such as "sub.gadget.Pin", labels of circuits are followed
+func (c *Circuit) wireOf(pin string) (*wire, error) {
+	if !strings.Contains(pin, ".") {
+		return nil, fmt.Errorf("pin must be of the form gadget.pin: %s", pin)
+	}
+	c.lock.RLock()
+	g, ok := c.gadgets[gadgetPart(pin)]
+	c.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("gadget not found for: %s", pin)
+	}
+	rest := pinPart(pin)
+	if sub, ok := g.circuitry.(*Circuit); ok {
+		sub.lock.RLock()
+		if internal, ok := sub.labels[rest]; ok {
+			rest = internal
+		}
+		sub.lock.RUnlock()
+		return sub.wireOf(rest)
+	}
+	g.mutex.Lock()
+	w := g.inputs[rest]
+	g.mutex.Unlock()
+	if w == nil {
+		return nil, fmt.Errorf("no wire to: %s", pin)
+	}
+	return w, nil
+}
+
+// Inject sends a message to an input pin of a running circuit, as if it came
+// from a gadget. The pin must be connected or fed, so that there is a wire.
+func (c *Circuit) Inject(pin string, m Message) error {
+	w, err := c.wireOf(pin)
+	if err != nil {
+		return err
+	}
+	w.mutex.RLock()
+	closed := w.closed
+	w.mutex.RUnlock()
+	if closed {
+		return fmt.Errorf("input is closed: %s", pin)
+	}
+	if atomic.LoadInt32(&w.dest.owner.dead) != 0 {
+		return errors.New("circuit has been stopped")
+	}
+	w.Send(m)
+	return nil
+}
+
+// Tap attaches a listener to an input pin, which then gets a copy of every
+// message sent to it. Messages are dropped when the returned channel is full.
+// Call the returned function to detach the listener again.
+func (c *Circuit) Tap(pin string, capacity int) (<-chan Message, func(), error) {
+	w, err := c.wireOf(pin)
+	if err != nil {
+		return nil, nil, err
+	}
+	tap := make(chan Message, capacity)
+	w.mutex.Lock()
+	if w.taps == nil {
+		w.taps = map[chan Message]bool{}
+	}
+	w.taps[tap] = true
+	atomic.AddInt32(&w.tapped, 1)
+	w.mutex.Unlock()
+	untap := func() {
+		w.mutex.Lock()
+		if w.taps[tap] {
+			delete(w.taps, tap)
+			atomic.AddInt32(&w.tapped, -1)
+		}
+		w.mutex.Unlock()
+	}
+	return tap, untap, nil
+}
+
+// give each tap a copy of a message, but never hold up the circuit for one,
+// this is called while sending, i.e. with the mutex held for reading
+func (c *wire) copyToTaps(v Message) {
+	for tap := range c.taps {
+		select {
+		case tap <- v:
+		default:
+		}
+	}
+}
+
+// A GadgetStatus is a snapshot of a gadget or circuit in a running circuit.
+type GadgetStatus struct {
+	Name    string                `json:"name"`
+	Type    string                `json:"type"`
+	Running bool                  `json:"running"`
+	Inputs  map[string]WireStatus `json:"inputs,omitempty"`
+	Gadgets []GadgetStatus        `json:"gadgets,omitempty"`
+}
+
+// A WireStatus reports the activity on the wire to an input pin.
+type WireStatus struct {
+	Queued   int   `json:"queued"`   // messages waiting to be received
+	Capacity int   `json:"capacity"` // size of the queue
+	Count    int64 `json:"count"`    // messages sent so far
+	Taps     int   `json:"taps,omitempty"`
+}
+
+// Status returns a snapshot of the circuit tree, with queue depths and message
+// counts of all wires.
+func (c *Circuit) Status() GadgetStatus {
+	st := c.Gadget.status()
+	st.Type = c.defName
+	c.lock.RLock()
+	types := map[string]string{}
+	for _, g := range c.gnames {
+		types[g.Name] = g.Type
+	}
+	names := sortedKeys(c.gadgets)
+	gadgets := make([]*Gadget, len(names))
+	for i, name := range names {
+		gadgets[i] = c.gadgets[name]
+	}
+	c.lock.RUnlock()
+	for i, name := range names {
+		g := gadgets[i]
+		var gs GadgetStatus
+		if sub, ok := g.circuitry.(*Circuit); ok {
+			gs = sub.Status()
+		} else {
+			gs = g.status()
+		}
+		gs.Name = name
+		if gs.Type = types[name]; gs.Type == "" {
+			gs.Type = fmt.Sprintf("%T", g.circuitry)
+		}
+		st.Gadgets = append(st.Gadgets, gs)
+	}
+	return st
+}
+
+func (g *Gadget) status() GadgetStatus {
+	g.mutex.Lock() // the inputs change when a circuit is patched
+	defer g.mutex.Unlock()
+	st := GadgetStatus{Name: g.name, Running: atomic.LoadInt32(&g.alive) != 0}
+	for pin, w := range g.inputs {
+		if st.Inputs == nil {
+			st.Inputs = map[string]WireStatus{}
+		}
+		w.mutex.RLock()
+		st.Inputs[pin] = WireStatus{
+			Queued:   w.queued(),
+			Capacity: w.capacity,
+			Count:    atomic.LoadInt64(&w.count),
+			Taps:     len(w.taps),
+		}
+		w.mutex.RUnlock()
+	}
+	return st
+}
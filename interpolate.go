@@ -0,0 +1,135 @@
+package flow
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var variablePattern = regexp.MustCompile(
+	`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// look up a variable, circuit parameters take precedence over Config settings
+func lookupVariable(name string, params map[string]interface{}) (interface{}, bool) {
+	if value, ok := params[name]; ok {
+		return value, true
+	}
+	value, ok := Config[name]
+	return value, ok
+}
+
+// Expand all ${NAME} and ${NAME:-default} references to parameters or Config
+// settings in a string, "$${" stands for a literal "${". A "$" which is not
+// followed by "{" is left alone. Undefined names without a default are
+// reported as error.
+func interpolate(s string, params map[string]interface{}) (string, error) {
+	var err error
+	result := variablePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := variablePattern.FindStringSubmatch(ref)
+		if value, ok := lookupVariable(m[1], params); ok {
+			return fmt.Sprint(value)
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("undefined variable: %s", m[1])
+		}
+		return ref
+	})
+	return result, err
+}
+
+// expand references in all strings inside a decoded JSON value, a string
+// which consists of just one reference to a parameter becomes its value as is
+func interpolateValue(v interface{}, params map[string]interface{}) (interface{}, error) {
+	var err error
+	switch x := v.(type) {
+	case string:
+		m := variablePattern.FindStringSubmatch(x)
+		if m != nil && m[0] == x && m[1] != "" {
+			if value, ok := params[m[1]]; ok {
+				return value, nil
+			}
+		}
+		return interpolate(x, params)
+	case []interface{}:
+		for i := range x {
+			if x[i], err = interpolateValue(x[i], params); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range x {
+			if x[k], err = interpolateValue(x[k], params); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// expand references in feed data, tags, gadget parameters, and in all gadget
+// names and types, including the pins which refer to those gadgets - the
+// params override the defaults declared in the definition
+func (conf *config) interpolate(params map[string]interface{}) error {
+	values := map[string]interface{}{}
+	for k, v := range conf.Params {
+		values[k] = v
+	}
+	for _, k := range sortedKeys(params) {
+		if _, ok := conf.Params[k]; !ok {
+			return fmt.Errorf("unknown parameter: %s", k)
+		}
+		values[k] = params[k]
+	}
+
+	var err error
+	expand := func(s *string) {
+		if err == nil {
+			*s, err = interpolate(*s, values)
+		}
+	}
+	expandValue := func(v *interface{}) {
+		if err == nil {
+			*v, err = interpolateValue(*v, values)
+		}
+	}
+	for i := range conf.Gadgets {
+		g := &conf.Gadgets[i]
+		expand(&g.Name)
+		expand(&g.Type)
+		for k := range g.Params {
+			v := g.Params[k]
+			expandValue(&v)
+			g.Params[k] = v
+		}
+	}
+	for i := range conf.Wires {
+		expand(&conf.Wires[i].From)
+		expand(&conf.Wires[i].To)
+	}
+	for i := range conf.Feeds {
+		expand(&conf.Feeds[i].Tag)
+		expand(&conf.Feeds[i].To)
+		expandValue(&conf.Feeds[i].Data)
+	}
+	for i := range conf.Labels {
+		expand(&conf.Labels[i].Internal)
+	}
+	if p := conf.Process; p != nil {
+		for i := range p.Command {
+			expand(&p.Command[i])
+		}
+		expand(&p.Dir)
+		for _, k := range sortedKeys(p.Env) {
+			v := p.Env[k]
+			expand(&v)
+			p.Env[k] = v
+		}
+	}
+	conf.Params = values
+	return err
+}
@@ -0,0 +1,85 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleCircuit_LoadJSON() {
+	flow.Config["GREETING"] = "hello"
+	flow.Config["OUTPUT"] = "Printer"
+	defer delete(flow.Config, "GREETING")
+	defer delete(flow.Config, "OUTPUT")
+
+	g := flow.NewCircuit()
+	err := g.LoadJSON([]byte(`{
+		"gadgets": [{ "name": "p", "type": "${OUTPUT}" }],
+		"feeds": [
+			{ "data": "${GREETING}, world", "to": "p.In" },
+			{ "data": ["${APPDIR:-./app}"], "to": "p.In" },
+			{ "data": "costs $5, or $$5, not $${GREETING}", "to": "p.In" }
+		]
+	}`))
+	fmt.Println(err)
+	g.Run()
+
+	err = flow.NewCircuit().LoadJSON([]byte(`{
+		"feeds": [{ "data": "${NO_SUCH_SETTING}", "to": "p.In" }]
+	}`))
+	fmt.Println(err)
+	// Output:
+	// <nil>
+	// hello, world
+	// [./app]
+	// costs $5, or $$5, not ${GREETING}
+	// undefined variable: NO_SUCH_SETTING
+}
+
+const paramsDefinitions = `{
+	"Reading": {
+		"params": { "sensor": "unknown", "rate": 1 },
+		"gadgets": [{ "name": "p", "type": "Printer" }],
+		"feeds": [
+			{ "data": "${sensor} every ${rate}s", "to": "p.In" },
+			{ "data": { "rate": "${rate}" }, "to": "p.In" }
+		]
+	},
+	"Readings": {
+		"gadgets": [
+			{ "name": "a", "type": "Reading" },
+			{ "name": "b", "type": "Reading",
+				"params": { "sensor": "temp", "rate": 5 } }
+		]
+	}
+}`
+
+func ExampleAddToRegistry_params() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	defs := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(defs, []byte(paramsDefinitions), 0666)
+	flow.AddToRegistry(defs)
+
+	flow.Registry["Reading"]().(*flow.Circuit).Run()
+	c := flow.NewCircuit()
+	c.Add("x", "Readings")
+	c.Run()
+
+	err := flow.NewCircuit().LoadJSON([]byte(`{"gadgets": [
+		{ "name": "p", "type": "Printer", "params": { "rate": 2 } }
+	]}`))
+	fmt.Println(err)
+	// Unordered output:
+	// no parameters allowed for: Printer
+	// unknown every 1s
+	// map[rate:1]
+	// unknown every 1s
+	// map[rate:1]
+	// temp every 5s
+	// map[rate:5]
+}
@@ -0,0 +1,566 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Severity levels of lint issues.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// A LintIssue describes one problem found in a circuit definitions file.
+type LintIssue struct {
+	File     string
+	Line     int
+	Column   int
+	Circuit  string
+	Severity string
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s: %s", i.File, i.Line, i.Column,
+		i.Severity, i.Circuit, i.Message)
+}
+
+// Kinds of pins, as found through reflection or via circuit labels.
+const (
+	pinInput = iota
+	pinOutput
+	pinOutputMap
+)
+
+// details about one pin of a gadget or circuit
+type pinInfo struct {
+	kind     int
+	optional bool // an input which may be left unconnected
+}
+
+// list all the pins of a gadget, with their kinds, inputs can be marked as
+// optional with a `flow:"optional"` struct tag
+func pinsOf(cy Circuitry) map[string]pinInfo {
+	pins := map[string]pinInfo{}
+	if c, ok := cy.(*Circuit); ok {
+		for ext, internal := range c.labels {
+			if g := c.gadgets[gadgetPart(internal)]; g != nil {
+				name := strings.Split(pinPart(internal), ":")[0]
+				if info, ok := pinsOf(g.circuitry)[name]; ok {
+					pins[ext] = info
+				}
+			}
+		}
+		return pins
+	}
+	v := reflect.ValueOf(cy)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return pins
+	}
+	t := v.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type {
+		case inputType:
+			pins[f.Name] = pinInfo{pinInput, f.Tag.Get("flow") == "optional"}
+		case outputType:
+			pins[f.Name] = pinInfo{kind: pinOutput}
+		case outputMapType:
+			pins[f.Name] = pinInfo{kind: pinOutputMap}
+		}
+	}
+	return pins
+}
+
+// source positions of all the items in one circuit definition
+type defPositions struct {
+	circuit                                int
+	gadgets, wires, feeds, labels, remotes []int
+}
+
+// Lint checks a definitions file, as read by AddToRegistry, against the given
+// registry. It reports unknown gadget types and pins, outputs connected twice,
+// unconnected outputs and inputs, bad labels, gadgets which will never receive
+// any messages, and cycles which will prevent a circuit from terminating.
+// Issues are sorted by position, the error is only set if the file cannot be
+// read or parsed.
+func Lint(registry map[string]func() Circuitry, filename string) ([]LintIssue, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := scanPositions(data)
+	if err != nil {
+		return nil, err
+	}
+	definitions, err := readDefinitions(filename)
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{
+		registry: registry,
+		defs:     map[string]*config{},
+		pins:     map[string]map[string]pinInfo{},
+		file:     filename,
+		data:     data,
+	}
+	for name, def := range definitions {
+		var conf config
+		if err := json.Unmarshal(def, &conf); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		l.defs[name] = &conf
+	}
+	for _, name := range sortedKeys(positions) {
+		l.circuit = name
+		l.pos = positions[name]
+		if err := l.defs[name].interpolate(nil); err != nil {
+			l.report(l.pos.circuit, LintError, "%s", err)
+		}
+		l.check(l.defs[name])
+	}
+	sort.Stable(byPosition(l.issues))
+	return l.issues, nil
+}
+
+type byPosition []LintIssue
+
+func (p byPosition) Len() int      { return len(p) }
+func (p byPosition) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPosition) Less(i, j int) bool {
+	if p[i].Line != p[j].Line {
+		return p[i].Line < p[j].Line
+	}
+	return p[i].Column < p[j].Column
+}
+
+type linter struct {
+	registry map[string]func() Circuitry
+	defs     map[string]*config
+	pins     map[string]map[string]pinInfo // cached per gadget type
+	file     string
+	data     []byte
+	issues   []LintIssue
+
+	circuit string       // name of the circuit being checked
+	pos     defPositions // positions within that circuit
+}
+
+func (l *linter) report(offset int, severity, format string, args ...interface{}) {
+	line, col := 1, 1
+	for _, ch := range l.data[:offset] {
+		if ch == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	l.issues = append(l.issues, LintIssue{l.file, line, col, l.circuit,
+		severity, fmt.Sprintf(format, args...)})
+}
+
+// return the position of the n'th item, or of the circuit if it's unknown
+func (l *linter) at(offsets []int, n int) int {
+	if n < len(offsets) {
+		return offsets[n]
+	}
+	return l.pos.circuit
+}
+
+// look up the pins of a gadget type, nil if the type is unknown
+func (l *linter) pinsOfType(typ string) map[string]pinInfo {
+	if pins, ok := l.pins[typ]; ok {
+		return pins
+	}
+	l.pins[typ] = nil // guards against recursive definitions
+	var pins map[string]pinInfo
+	def := l.defs[typ]
+	if raw := circuitDefs[typ]; def == nil && raw != nil && l.registry[typ] != nil {
+		def, _ = parseDefinition(raw, nil) // registered, no need to instantiate
+	}
+	if def != nil && def.Process != nil {
+		pins = pinsOf(new(Process))
+	} else if def != nil {
+		pins = map[string]pinInfo{}
+		for _, lbl := range def.Labels {
+			for _, g := range def.Gadgets {
+				if g.Name == gadgetPart(lbl.Internal) {
+					name := strings.Split(pinPart(lbl.Internal), ":")[0]
+					if info, ok := l.pinsOfType(g.Type)[name]; ok {
+						pins[lbl.External] = info
+					}
+				}
+			}
+		}
+	} else if factory := l.registry[typ]; factory != nil {
+		pins = pinsOf(factory())
+	}
+	l.pins[typ] = pins
+	return pins
+}
+
+func (l *linter) check(conf *config) {
+	types := map[string]string{}
+	for i, g := range conf.Gadgets {
+		at := l.at(l.pos.gadgets, i)
+		if _, ok := types[g.Name]; ok {
+			l.report(at, LintError, "duplicate gadget name: %s", g.Name)
+		}
+		if g.Name == "" || strings.Contains(g.Name, ".") {
+			l.report(at, LintError, "invalid gadget name: %q", g.Name)
+		}
+		types[g.Name] = g.Type
+		if l.pinsOfType(g.Type) == nil {
+			l.report(at, LintError, "unknown gadget type: %s", g.Type)
+		}
+	}
+
+	// look up a pin, reporting problems, returns false if it's not usable
+	lookup := func(at int, pin string, kinds ...int) bool {
+		if !strings.Contains(pin, ".") {
+			l.report(at, LintError, "pin must be of the form gadget.pin: %s", pin)
+			return false
+		}
+		typ, ok := types[gadgetPart(pin)]
+		if !ok {
+			l.report(at, LintError, "gadget not found for: %s", pin)
+			return false
+		}
+		pins := l.pinsOfType(typ)
+		if pins == nil {
+			return false // already reported as unknown type
+		}
+		name := strings.Split(pinPart(pin), ":")[0]
+		info, ok := pins[name]
+		if !ok {
+			l.report(at, LintError, "%s has no pin named %s: %s", typ, name, pin)
+			return false
+		}
+		if (info.kind == pinOutputMap) != strings.Contains(pinPart(pin), ":") {
+			l.report(at, LintError, "map pins must be used as %s:name: %s",
+				name, pin)
+			return false
+		}
+		for _, k := range kinds {
+			if info.kind == k {
+				return true
+			}
+		}
+		if info.kind == pinInput {
+			l.report(at, LintError, "input pin used as output: %s", pin)
+		} else {
+			l.report(at, LintError, "output pin used as input: %s", pin)
+		}
+		return false
+	}
+
+	used := map[string]bool{}   // pins which are wired, fed, or labeled
+	sources := map[string]int{} // output pins, and the wire which uses it
+	edges := map[string][]string{}
+	for i, w := range conf.Wires {
+		at := l.at(l.pos.wires, i)
+		okFrom := lookup(at, w.From, pinOutput, pinOutputMap)
+		okTo := lookup(at, w.To, pinInput)
+		if okFrom {
+			if prev, ok := sources[w.From]; ok {
+				l.report(at, LintError, "output already connected by wire %d: %s",
+					prev+1, w.From)
+			}
+			sources[w.From] = i
+		}
+		if okFrom && okTo {
+			from, to := gadgetPart(w.From), gadgetPart(w.To)
+			edges[from] = append(edges[from], to)
+		}
+		if w.Journal != "" && w.Codec != "" && Codecs[w.Codec] == nil {
+			l.report(at, LintError, "codec not found: %s", w.Codec)
+		}
+		used[w.From] = true
+		used[w.To] = true
+	}
+	for i, f := range conf.Feeds {
+		at := l.at(l.pos.feeds, i)
+		if lookup(at, f.To, pinInput) {
+			if err := checkFeed(types[gadgetPart(f.To)], f); err != nil {
+				l.report(at, LintError, "bad feed for %s: %s", f.To, err)
+			}
+		}
+		used[f.To] = true
+	}
+	for i, lbl := range conf.Labels {
+		at := l.at(l.pos.labels, i)
+		if strings.Contains(lbl.External, ".") {
+			l.report(at, LintError, "external pin should not include a dot: %s",
+				lbl.External)
+		}
+		lookup(at, lbl.Internal, pinInput, pinOutput, pinOutputMap)
+		used[lbl.Internal] = true
+	}
+	for i, r := range conf.Remotes {
+		at := l.at(l.pos.remotes, i)
+		switch {
+		case (r.From == "") == (r.To == ""):
+			l.report(at, LintError, "remote needs either from or to: %s", r.Addr)
+		case r.From != "":
+			if lookup(at, r.From, pinOutput, pinOutputMap) {
+				if prev, ok := sources[r.From]; ok {
+					l.report(at, LintError,
+						"output already connected by wire %d: %s", prev+1, r.From)
+				}
+			}
+		default:
+			lookup(at, r.To, pinInput)
+		}
+		if r.Codec != "" && Codecs[r.Codec] == nil {
+			l.report(at, LintError, "codec not found: %s", r.Codec)
+		}
+		used[r.From+r.To] = true
+	}
+
+	// check for unconnected pins, and find all gadgets which get messages
+	reached := map[string]bool{}
+	roots := []string{}
+	for i, g := range conf.Gadgets {
+		at := l.at(l.pos.gadgets, i)
+		pins := l.pinsOfType(g.Type)
+		if pins == nil {
+			reached[g.Name] = true // can't tell, don't report it again
+			continue
+		}
+		inputs := 0
+		for _, name := range sortedKeys(pins) {
+			pin := g.Name + "." + name
+			switch info := pins[name]; info.kind {
+			case pinInput:
+				inputs++
+				if !used[pin] && !info.optional {
+					l.report(at, LintWarning, "input not connected: %s", pin)
+				}
+			case pinOutput:
+				if !used[pin] {
+					l.report(at, LintWarning,
+						"output not connected, messages will be lost: %s", pin)
+				}
+			case pinOutputMap:
+				if !usedPrefix(used, pin+":") {
+					l.report(at, LintWarning,
+						"output not connected, messages will be lost: %s", pin)
+				}
+			}
+		}
+		if inputs == 0 || hasFeedOrLabel(conf, g.Name) {
+			roots = append(roots, g.Name)
+		}
+	}
+	var visit func(name string)
+	visit = func(name string) {
+		if !reached[name] {
+			reached[name] = true
+			for _, next := range edges[name] {
+				visit(next)
+			}
+		}
+	}
+	for _, name := range roots {
+		visit(name)
+	}
+	for i, g := range conf.Gadgets {
+		if !reached[g.Name] {
+			l.report(l.at(l.pos.gadgets, i), LintWarning,
+				"gadget will never receive any messages: %s", g.Name)
+		}
+	}
+
+	// report each cycle once, at the first gadget definition involved
+	index := map[string]int{}
+	for i, g := range conf.Gadgets {
+		if _, ok := index[g.Name]; !ok {
+			index[g.Name] = i
+		}
+	}
+	for _, cycle := range findCycles(conf.Gadgets, edges) {
+		first := index[cycle[0]]
+		for _, name := range cycle {
+			if index[name] < first {
+				first = index[name]
+			}
+		}
+		l.report(l.at(l.pos.gadgets, first), LintWarning,
+			"cycle will never terminate: %s", strings.Join(cycle, " -> "))
+	}
+}
+
+func usedPrefix(used map[string]bool, prefix string) bool {
+	for pin := range used {
+		if strings.HasPrefix(pin, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// report whether a gadget has an external source of messages
+func hasFeedOrLabel(conf *config, name string) bool {
+	for _, f := range conf.Feeds {
+		if gadgetPart(f.To) == name {
+			return true
+		}
+	}
+	for _, lbl := range conf.Labels {
+		if gadgetPart(lbl.Internal) == name {
+			return true
+		}
+	}
+	for _, r := range conf.Remotes {
+		if r.To != "" && gadgetPart(r.To) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// find all cycles, as strongly connected components (Tarjan's algorithm)
+func findCycles(gadgets []gadgetDef, edges map[string][]string) [][]string {
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	cycles := [][]string{}
+	var connect func(v string)
+	connect = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		selfLoop := false
+		for _, w := range edges[v] {
+			if w == v {
+				selfLoop = true
+			}
+			if _, ok := index[w]; !ok {
+				connect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] == index[v] {
+			scc := []string{}
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append([]string{w}, scc...)
+				if w == v {
+					break
+				}
+			}
+			if len(scc) > 1 || selfLoop {
+				cycles = append(cycles, append(scc, scc[0]))
+			}
+		}
+	}
+	for _, g := range gadgets {
+		if _, ok := index[g.Name]; !ok {
+			connect(g.Name)
+		}
+	}
+	return cycles
+}
+
+// find the offsets of all circuits and their items in a definitions file
+func scanPositions(data []byte) (map[string]defPositions, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	next := func() int {
+		off := int(dec.InputOffset())
+		for off < len(data) && strings.IndexByte(" \t\r\n:,", data[off]) >= 0 {
+			off++
+		}
+		return off
+	}
+	expect := func(delim json.Delim) error {
+		t, err := dec.Token()
+		if err == nil && t != delim {
+			err = fmt.Errorf("expected %v at offset %d", delim, dec.InputOffset())
+		}
+		return err
+	}
+	var skip json.RawMessage
+
+	positions := map[string]defPositions{}
+	if err := expect('{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := t.(string)
+		pos := defPositions{circuit: next()}
+		if name == "imports" {
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect('{'); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			var list *[]int
+			switch key, _ := t.(string); strings.ToLower(key) {
+			case "gadgets":
+				list = &pos.gadgets
+			case "wires":
+				list = &pos.wires
+			case "feeds":
+				list = &pos.feeds
+			case "labels":
+				list = &pos.labels
+			case "remotes":
+				list = &pos.remotes
+			default:
+				if err := dec.Decode(&skip); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switch t, err := dec.Token(); {
+			case err != nil:
+				return nil, err
+			case t == nil:
+				continue // a null list has no items
+			case t != json.Delim('['):
+				return nil, fmt.Errorf("expected [ at offset %d", dec.InputOffset())
+			}
+			for dec.More() {
+				*list = append(*list, next())
+				if err := dec.Decode(&skip); err != nil {
+					return nil, err
+				}
+			}
+			if err := expect(']'); err != nil {
+				return nil, err
+			}
+		}
+		if err := expect('}'); err != nil {
+			return nil, err
+		}
+		positions[name] = pos
+	}
+	return positions, nil
+}
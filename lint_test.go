@@ -0,0 +1,58 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+const lintSetup = `{
+  "main": {
+    "gadgets": [
+      {"name": "r", "type": "Repeater"},
+      {"name": "x", "type": "NoSuchGadget"},
+      {"name": "p1", "type": "Pipe"},
+      {"name": "p2", "type": "Pipe"},
+      {"name": "c", "type": "Counter"}
+    ],
+    "wires": [
+      {"from": "r.Out", "to": "c.In"},
+      {"from": "r.Out", "to": "c.Num"},
+      {"from": "p1.Out", "to": "p2.In"},
+      {"from": "p2.Out", "to": "p1.In"}
+    ],
+    "feeds": [
+      {"data": "abc", "to": "r.In"}
+    ],
+    "labels": [
+      {"external": "Out", "internal": "q.Out"}
+    ]
+  }
+}`
+
+func ExampleLint() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	setup := filepath.Join(dir, "setup.json")
+	ioutil.WriteFile(setup, []byte(lintSetup), 0666)
+
+	issues, _ := flow.Lint(flow.Registry, setup)
+	for _, issue := range issues {
+		fmt.Printf("%d:%d: %s: %s\n", issue.Line, issue.Column,
+			issue.Severity, issue.Message)
+	}
+	// Output:
+	// 4:7: warning: input not connected: r.Num
+	// 5:7: error: unknown gadget type: NoSuchGadget
+	// 6:7: warning: gadget will never receive any messages: p1
+	// 6:7: warning: cycle will never terminate: p1 -> p2 -> p1
+	// 7:7: warning: gadget will never receive any messages: p2
+	// 8:7: warning: output not connected, messages will be lost: c.Out
+	// 12:7: error: Counter has no pin named Num: c.Num
+	// 12:7: error: output already connected by wire 1: r.Out
+	// 20:7: error: gadget not found for: q.Out
+}
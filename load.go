@@ -2,47 +2,139 @@ package flow
 
 import (
 	"encoding/json"
+	"io"
 )
 
+// definition of a circuit, as loaded from JSON
 type config struct {
-	Gadgets []struct {
-		Type, Name string
-	}
-	Wires []struct {
-		From, To string
-		Capacity int
-	}
-	Feeds []struct {
-		Tag  string
-		Data interface{}
-		To   string
-	}
-	Labels []struct {
-		External, Internal string
-	}
+	Params  map[string]interface{} // declared parameters, with their defaults
+	Gadgets []gadgetDef
+	Wires   []wireDef
+	Feeds   []feedDef
+	Labels  []labelDef
+	Remotes []remoteDef
+	Process *processDef // set if this runs an external process instead
 }
 
-// Load a circuit from a JSON description in a string.
+// definition of one initial message
+type feedDef struct {
+	Tag  string
+	Data interface{}
+	To   string
+}
+
+// definition of one external pin label
+type labelDef struct {
+	External, Internal string
+}
+
+// Load a circuit from a JSON description in a string. References to Config
+// settings, as ${NAME} or ${NAME:-default}, are expanded in feed data and in
+// gadget names and types. Parameters declared in the definition's "params"
+// can be referenced in the same way, they take precedence over Config.
 func (c *Circuit) LoadJSON(data []byte) error {
+	return c.loadJSON(data, nil)
+}
+
+// load a definition, with parameter values overriding the declared defaults
+func (c *Circuit) loadJSON(data []byte, params map[string]interface{}) error {
+	conf, err := parseDefinition(data, params)
+	if err == nil {
+		c.params = params
+		err = c.build(conf)
+	}
+	return err
+}
+
+// parse a definition and expand all references to parameters and settings
+func parseDefinition(data []byte, params map[string]interface{}) (*config, error) {
 	var conf config
 	err := json.Unmarshal(data, &conf)
 	if err == nil {
-		for _, g := range conf.Gadgets {
-			c.Add(g.Name, g.Type)
+		err = conf.interpolate(params)
+	}
+	return &conf, err
+}
+
+// set up all the gadgets, wires, feeds, and labels of a parsed definition,
+// fails if a gadget cannot be created with the parameters given to it
+func (c *Circuit) build(conf *config) error {
+	for _, g := range conf.Gadgets {
+		if err := c.add(g); err != nil {
+			return err
 		}
-		for _, w := range conf.Wires {
+	}
+	for _, w := range conf.Wires {
+		if w.Journal != "" {
+			c.ConnectDurable(w.From, w.To, w.Capacity, w.Journal, w.Codec)
+		} else if w.Priority {
+			c.ConnectPriority(w.From, w.To, w.Capacity)
+		} else {
 			c.Connect(w.From, w.To, w.Capacity)
 		}
-		for _, f := range conf.Feeds {
-			if f.Tag != "" {
-				c.Feed(f.To, Tag{f.Tag, f.Data})
+	}
+	for _, f := range conf.Feeds {
+		c.Feed(f.To, f.message())
+	}
+	for _, l := range conf.Labels {
+		c.Label(l.External, l.Internal)
+	}
+	for _, r := range conf.Remotes {
+		if r.From != "" {
+			c.SendRemote(r.From, r.Addr, r.Codec)
+		} else {
+			c.ReceiveRemote(r.Addr, r.To, r.Codec, r.Window)
+		}
+	}
+	c.loaded = conf
+	return nil
+}
+
+// the message to feed, which is a tag if the definition includes a tag name
+func (f *feedDef) message() Message {
+	if f.Tag != "" {
+		return Tag{f.Tag, f.Data}
+	}
+	return f.Data
+}
+
+// WriteJSON writes the circuit's definition in the format read by LoadJSON.
+// Gadgets which were not added from the registry are left out.
+func (c *Circuit) WriteJSON(w io.Writer) error {
+	type feed struct {
+		Tag  string  `json:"tag,omitempty"`
+		Data Message `json:"data"`
+		To   string  `json:"to"`
+	}
+	type label struct {
+		External string `json:"external"`
+		Internal string `json:"internal"`
+	}
+	var def struct {
+		Gadgets []gadgetDef `json:"gadgets,omitempty"`
+		Wires   []wireDef   `json:"wires,omitempty"`
+		Feeds   []feed      `json:"feeds,omitempty"`
+		Labels  []label     `json:"labels,omitempty"`
+		Remotes []remoteDef `json:"remotes,omitempty"`
+	}
+	def.Gadgets = c.gnames
+	def.Wires = c.wires
+	def.Remotes = c.remotes
+	for _, pin := range sortedKeys(c.feeds) {
+		for _, m := range c.feeds[pin] {
+			if t, ok := m.(Tag); ok {
+				def.Feeds = append(def.Feeds, feed{t.Tag, t.Msg, pin})
 			} else {
-				c.Feed(f.To, f.Data)
+				def.Feeds = append(def.Feeds, feed{Data: m, To: pin})
 			}
 		}
-		for _, l := range conf.Labels {
-			c.Label(l.External, l.Internal)
-		}
+	}
+	for _, ext := range sortedKeys(c.labels) {
+		def.Labels = append(def.Labels, label{ext, c.labels[ext]})
+	}
+	data, err := json.MarshalIndent(def, "", "  ")
+	if err == nil {
+		_, err = w.Write(append(data, '\n'))
 	}
 	return err
 }
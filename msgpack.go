@@ -0,0 +1,375 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// MessagePack extension type used for tags and registered types, its payload
+// is a two-element array with the type name and the value.
+const msgpackTypedExt = 1
+
+// The MessagePack codec is compact and preserves integers, byte slices, tags,
+// and registered types. Unregistered structs are decoded as maps, as with JSON.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(m Message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := msgpackEncode(&buf, m)
+	return buf.Bytes(), err
+}
+
+func (msgpackCodec) Decode(data []byte) (Message, error) {
+	r := bytes.NewReader(data)
+	m, err := msgpackDecode(r)
+	if err == nil && r.Len() > 0 {
+		err = errors.New("msgpack: trailing data")
+	}
+	return m, err
+}
+
+func msgpackEncode(buf *bytes.Buffer, m Message) error {
+	put := func(code byte, v interface{}) {
+		buf.WriteByte(code)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+	header := func(n int, fix, fixMax, c16, c32 byte) {
+		switch {
+		case n <= int(fixMax):
+			buf.WriteByte(fix | byte(n))
+		case n <= math.MaxUint16:
+			put(c16, uint16(n))
+		default:
+			put(c32, uint32(n))
+		}
+	}
+
+	switch v := m.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+		return nil
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+		return nil
+	case string:
+		if len(v) <= 31 {
+			buf.WriteByte(0xa0 | byte(len(v)))
+		} else if len(v) <= math.MaxUint8 {
+			put(0xd9, uint8(len(v)))
+		} else {
+			header(len(v), 0, 0, 0xda, 0xdb)
+		}
+		buf.WriteString(v)
+		return nil
+	case []byte:
+		if len(v) <= math.MaxUint8 {
+			put(0xc4, uint8(len(v)))
+		} else {
+			header(len(v), 0, 0, 0xc5, 0xc6)
+		}
+		buf.Write(v)
+		return nil
+	case float32:
+		put(0xca, v)
+		return nil
+	case float64:
+		put(0xcb, v)
+		return nil
+	case Tag:
+		return msgpackTyped(buf, "Tag", []interface{}{v.Tag, v.Msg})
+	case []interface{}:
+		header(len(v), 0x90, 15, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := msgpackEncode(buf, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		header(len(v), 0x80, 15, 0xde, 0xdf)
+		for _, k := range sortedKeys(v) {
+			msgpackEncode(buf, k)
+			if err := msgpackEncode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if name, ok := typeNames[reflect.TypeOf(m)]; ok {
+		g, err := genericValue(m)
+		if err != nil {
+			return err
+		}
+		return msgpackTyped(buf, name, g)
+	}
+	rv := reflect.ValueOf(m)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		switch {
+		case n >= 0:
+			return msgpackEncode(buf, uint64(n))
+		case n >= -32:
+			buf.WriteByte(byte(n))
+		case n >= math.MinInt8:
+			put(0xd0, int8(n))
+		case n >= math.MinInt16:
+			put(0xd1, int16(n))
+		case n >= math.MinInt32:
+			put(0xd2, int32(n))
+		default:
+			put(0xd3, n)
+		}
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		switch {
+		case n <= 127:
+			buf.WriteByte(byte(n))
+		case n <= math.MaxUint8:
+			put(0xcc, uint8(n))
+		case n <= math.MaxUint16:
+			put(0xcd, uint16(n))
+		case n <= math.MaxUint32:
+			put(0xce, uint32(n))
+		default:
+			put(0xcf, n)
+		}
+		return nil
+	case reflect.String:
+		return msgpackEncode(buf, rv.String())
+	}
+
+	// anything else is sent the way JSON would represent it
+	g, err := genericValue(m)
+	if err != nil {
+		return err
+	}
+	if reflect.TypeOf(g) == reflect.TypeOf(m) {
+		return fmt.Errorf("msgpack: cannot encode %T", m)
+	}
+	return msgpackEncode(buf, g)
+}
+
+func msgpackTyped(buf *bytes.Buffer, name string, v interface{}) error {
+	var payload bytes.Buffer
+	if err := msgpackEncode(&payload, []interface{}{name, v}); err != nil {
+		return err
+	}
+	n := payload.Len()
+	switch {
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xc7, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc8)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc9)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteByte(msgpackTypedExt)
+	buf.Write(payload.Bytes())
+	return nil
+}
+
+func msgpackDecode(r *bytes.Reader) (Message, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	get := func(v interface{}) error {
+		return binary.Read(r, binary.BigEndian, v)
+	}
+	size := func(bits int) (int, error) {
+		switch bits {
+		case 8:
+			var n uint8
+			err := get(&n)
+			return int(n), err
+		case 16:
+			var n uint16
+			err := get(&n)
+			return int(n), err
+		}
+		var n uint32
+		err := get(&n)
+		if int64(n) > int64(r.Len()) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return int(n), err
+	}
+	raw := func(n int) ([]byte, error) {
+		if n > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	array := func(n int) (Message, error) {
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := msgpackDecode(r)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	}
+	object := func(n int) (Message, error) {
+		obj := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := msgpackDecode(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := msgpackDecode(r)
+			if err != nil {
+				return nil, err
+			}
+			obj[fmt.Sprint(k)] = v
+		}
+		return obj, nil
+	}
+	integer := func(v int64) Message {
+		if int64(int(v)) == v {
+			return int(v)
+		}
+		return v
+	}
+
+	switch {
+	case code <= 0x7f:
+		return int(code), nil
+	case code >= 0xe0:
+		return int(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		data, err := raw(int(code & 0x1f))
+		return string(data), err
+	case code&0xf0 == 0x90:
+		return array(int(code & 0x0f))
+	case code&0xf0 == 0x80:
+		return object(int(code & 0x0f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := size(8 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return raw(n)
+	case 0xd9, 0xda, 0xdb:
+		n, err := size(8 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		data, err := raw(n)
+		return string(data), err
+	case 0xdc, 0xdd:
+		n, err := size(16 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return array(n)
+	case 0xde, 0xdf:
+		n, err := size(16 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return object(n)
+	case 0xca:
+		var f float32
+		err := get(&f)
+		return f, err
+	case 0xcb:
+		var f float64
+		err := get(&f)
+		return f, err
+	case 0xcc:
+		var n uint8
+		err := get(&n)
+		return int(n), err
+	case 0xcd:
+		var n uint16
+		err := get(&n)
+		return int(n), err
+	case 0xce:
+		var n uint32
+		err := get(&n)
+		return integer(int64(n)), err
+	case 0xcf:
+		var n uint64
+		err := get(&n)
+		if n > math.MaxInt64 {
+			return n, err
+		}
+		return integer(int64(n)), err
+	case 0xd0:
+		var n int8
+		err := get(&n)
+		return int(n), err
+	case 0xd1:
+		var n int16
+		err := get(&n)
+		return int(n), err
+	case 0xd2:
+		var n int32
+		err := get(&n)
+		return int(n), err
+	case 0xd3:
+		var n int64
+		err := get(&n)
+		return integer(n), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := size(8 << (code - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		ext, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if ext != msgpackTypedExt {
+			data, err := raw(n)
+			return data, err
+		}
+		v, err := msgpackDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		pair, ok := v.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, errors.New("msgpack: bad typed value")
+		}
+		name, _ := pair[0].(string)
+		if name == "Tag" {
+			inner, ok := pair[1].([]interface{})
+			if !ok || len(inner) != 2 {
+				return nil, errors.New("msgpack: bad tag")
+			}
+			tag, _ := inner[0].(string)
+			return Tag{tag, inner[1]}, nil
+		}
+		return typedValue(name, pair[1])
+	}
+	return nil, fmt.Errorf("msgpack: unsupported code 0x%02x", code)
+}
@@ -0,0 +1,135 @@
+package flow
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plugins lists the registry entries added or replaced by each Go plugin
+// loaded with LoadPlugins, by plugin file name.
+var Plugins = map[string][]string{}
+
+// plugins which were rejected after they were opened, with the reason, since
+// they can't be opened again
+var rejectedPlugins = map[string]error{}
+
+// registryMutex is held while this package changes the registry after startup,
+// i.e. when loading plugins and definitions files. Lookups are not locked, so
+// plugins should be loaded before any circuits are started.
+var registryMutex sync.Mutex
+
+// LoadPlugins opens all Go plugins, i.e. files ending in ".so", in a directory.
+// If the directory is empty, the PLUGINS setting in Config is used, if set.
+// Plugins are built with "go build -buildmode=plugin" and register their
+// gadgets from init functions, as any other package. These run while the
+// registry is being changed and must not load definitions files or plugins
+// themselves. Plugins must also export the version of this package they were
+// built against, as:
+//
+//	var FlowVersion = flow.Version
+//
+// Plugins built against a different version are rejected. Their init functions
+// have already run by then and there is no way to undo all their effects, so
+// the process should exit when an error is returned. Loading such a plugin
+// again reports the same error. Loading stops at the first plugin which fails.
+func LoadPlugins(dir string) error {
+	if dir == "" {
+		dir = Config["PLUGINS"]
+		if dir == "" {
+			return nil
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, file := range files {
+		if err := loadPlugin(file); err != nil {
+			return fmt.Errorf("plugin %s: %s", file, err)
+		}
+	}
+	return nil
+}
+
+// open one plugin and keep track of what it added to the registry
+func loadPlugin(file string) error {
+	name := filepath.Base(file)
+	if _, ok := Plugins[name]; ok {
+		return nil // already loaded, Go plugins can't be opened twice anyway
+	}
+	if err, ok := rejectedPlugins[name]; ok {
+		return err
+	}
+	registry := map[string]func() Circuitry{}
+	for k, f := range Registry {
+		registry[k] = f
+	}
+	help := map[string]string{}
+	for k, s := range Help {
+		help[k] = s
+	}
+
+	p, err := plugin.Open(file)
+	if err != nil {
+		if strings.Contains(err.Error(), "different version") {
+			return fmt.Errorf("incompatible build: %s", err)
+		}
+		return err
+	}
+	entries := []string{}
+	for k, f := range Registry {
+		old, ok := registry[k]
+		if !ok || reflect.ValueOf(old).Pointer() != reflect.ValueOf(f).Pointer() {
+			entries = append(entries, k)
+		}
+	}
+	sort.Strings(entries)
+
+	version := "(none)"
+	if sym, err := p.Lookup("FlowVersion"); err == nil {
+		if v, ok := sym.(*string); ok {
+			version = *v
+		}
+	}
+	if version != Version {
+		// can't undo a plugin's init, this only keeps its gadgets out of use
+		for _, k := range entries {
+			delete(Registry, k)
+			delete(Help, k)
+		}
+		for k, f := range registry {
+			Registry[k] = f
+		}
+		for k, s := range help {
+			Help[k] = s
+		}
+		err := fmt.Errorf("built for flow version %s, this is %s"+
+			" (its init has run, exit and rebuild it)", version, Version)
+		rejectedPlugins[name] = err
+		return err
+	}
+	Plugins[name] = entries
+	return nil
+}
+
+// report which plugin provided a registry entry, if any
+func pluginOf(entry string) string {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for name, entries := range Plugins {
+		for _, e := range entries {
+			if e == entry {
+				return name
+			}
+		}
+	}
+	return ""
+}
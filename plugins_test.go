@@ -0,0 +1,50 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcw/flow"
+)
+
+func ExampleLoadPlugins() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	fmt.Println(flow.LoadPlugins(dir), len(flow.Plugins))
+
+	ioutil.WriteFile(filepath.Join(dir, "bad.so"), []byte("not a plugin"), 0666)
+	err := flow.LoadPlugins(dir)
+	fmt.Println(err != nil, len(flow.Plugins))
+	// Output:
+	// <nil> 0
+	// true 0
+}
+
+func TestLoadPlugins_version(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a plugin")
+	}
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("go", "build", "-buildmode=plugin",
+		"-o", filepath.Join(dir, "old.so"), "./testdata/oldplugin")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("can't build plugin: %s\n%s", err, out)
+	}
+
+	err := flow.LoadPlugins(dir)
+	if err != nil && strings.Contains(err.Error(), "incompatible build") {
+		t.Skip("test binary built with different flags:", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "built for flow version 0.0.1") {
+		t.Fatal("version mismatch not reported:", err)
+	}
+	if flow.Registry["OldPluginGadget"] != nil || len(flow.Plugins) != 0 {
+		t.Fatal("rejected plugin still registered")
+	}
+}
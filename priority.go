@@ -0,0 +1,270 @@
+package flow
+
+import (
+	"container/heap"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// Number of control messages which can be pending for each gadget.
+const controlCapacity = 10
+
+// A priority message overtakes lower-level messages queued on a priority wire.
+// The wrapper is removed on delivery, the receiving gadget only sees the Msg.
+type Priority struct {
+	Level int
+	Msg   Message
+}
+
+// A control message is delivered out-of-band, on the Control channel of the
+// receiving gadget instead of on the input pin it was sent to.
+type Control struct {
+	Msg Message
+}
+
+// Connect an output pin with an input pin, using a wire which delivers queued
+// messages by decreasing priority level instead of in strict FIFO order.
+func (c *Circuit) ConnectPriority(from, to string, capacity int) {
+	c.wires = append(c.wires, wireDef{From: from, To: to, Capacity: capacity,
+		Priority: true})
+	w := c.gadgetOf(to).getInput(pinPart(to), capacity)
+	w.priority = true
+	c.gadgetOf(from).setOutput(pinPart(from), w)
+}
+
+// Send a control message to a gadget in this circuit, bypassing its inputs.
+// An input pin can also be given, to reach the gadget inside a nested circuit
+// which reads from it, the same one as for a Control message fed to that pin.
+// The message is dropped with a warning if the gadget has too many pending.
+func (c *Circuit) SendControl(gadget string, m Message) {
+	name, pin := gadget, ""
+	if strings.Contains(gadget, ".") {
+		name, pin = gadgetPart(gadget), pinPart(gadget)
+	}
+	c.lock.RLock()
+	g, ok := c.gadgets[name]
+	c.lock.RUnlock()
+	if !ok {
+		glog.Fatalln("gadget not found:", gadget)
+	}
+	if pin != "" {
+		g = g.reader(pin)
+	}
+	select {
+	case g.control <- m:
+	default:
+		glog.Warningln("dropped, too many control messages:", gadget, m)
+	}
+}
+
+// Control returns the channel on which out-of-band control messages arrive.
+func (g *Gadget) Control() Input {
+	return g.control
+}
+
+// Next returns the next message from an input pin, but gives precedence to
+// any pending control messages, which are returned wrapped in a Control.
+// This avoids having to select over the control channel in each gadget.
+func (g *Gadget) Next(in Input) (Message, bool) {
+	select {
+	case m := <-g.control:
+		return Control{m}, true
+	default:
+	}
+	select {
+	case m := <-g.control:
+		return Control{m}, true
+	case m, ok := <-in:
+		return m, ok
+	}
+}
+
+// divert control messages and strip priorities on plain FIFO wires, returns
+// false if the message has been dealt with and should not be queued
+func (c *wire) filter(v Message) (Message, bool) {
+	switch m := v.(type) {
+	case Control:
+		g := c.target()
+		select {
+		case g.control <- m.Msg:
+		case <-g.owner.done:
+		}
+		return nil, false
+	case Priority:
+		if !c.priority {
+			return m.Msg, true
+		}
+	}
+	return v, true
+}
+
+// the gadget which reads from this wire, looking inside nested circuits
+func (c *wire) target() *Gadget {
+	return c.dest.reader(c.pin)
+}
+
+// the gadget which reads from an input pin, looking inside nested circuits
+func (g *Gadget) reader(pin string) *Gadget {
+	for {
+		inner, ok := g.circuitry.(*Circuit)
+		if !ok {
+			return g
+		}
+		inner.lock.RLock()
+		p, ok := inner.labels[pin]
+		inner.lock.RUnlock()
+		if !ok {
+			return g
+		}
+		g, pin = inner.gadgetOf(p), pinPart(p)
+	}
+}
+
+// a control message fed to a gadget which had no room for it at startup
+type pendingControl struct {
+	to  *Gadget
+	msg Message
+}
+
+// take the control messages out of a wire's feeds, those which don't fit in
+// the control channel yet are added to late, so that setup never blocks
+func (c *wire) divertControl(feeds []Message, late []pendingControl) ([]Message, []pendingControl) {
+	var data []Message
+	for _, msg := range feeds {
+		ctl, ok := msg.(Control)
+		if !ok {
+			data = append(data, msg)
+			continue
+		}
+		g := c.target()
+		if len(late) == 0 { // keep them in order once one is late
+			select {
+			case g.control <- ctl.Msg:
+				continue
+			default:
+			}
+		}
+		late = append(late, pendingControl{g, ctl.Msg})
+	}
+	return data, late
+}
+
+// deliver control messages which didn't fit, until the gadget is done
+func deliverControl(late []pendingControl, quit, done chan struct{}) {
+	for _, p := range late {
+		select {
+		case p.to.control <- p.msg:
+		case <-quit:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// set up a pump between the wire's inbox and the channel seen by the gadget,
+// it stops delivering when quit is closed, i.e. once the gadget has exited
+func (c *wire) prioritize(quit chan struct{}) chan Message {
+	out := make(chan Message)
+	limit := c.capacity
+	if limit < 1 {
+		limit = 1
+	}
+	inbox := c.channel
+	go func() {
+		var queue priorityQueue
+		seq := 0
+		push := func(m Message) {
+			item := prioItem{seq: seq, msg: m}
+			if p, ok := m.(Priority); ok {
+				item.level = p.Level
+				item.msg = p.Msg
+			}
+			seq++
+			heap.Push(&queue, item)
+		}
+		defer atomic.StoreInt64(&c.backlog, 0)
+		for inbox != nil || len(queue) > 0 {
+			atomic.StoreInt64(&c.backlog, int64(len(queue)))
+			// grab everything that is already waiting, to allow overtaking
+			for draining := true; draining && inbox != nil && len(queue) < limit; {
+				select {
+				case m, ok := <-inbox:
+					if ok {
+						push(m)
+					} else {
+						inbox = nil
+					}
+				default:
+					draining = false
+				}
+			}
+			if len(queue) == 0 {
+				if inbox == nil {
+					break
+				}
+				select {
+				case m, ok := <-inbox:
+					if ok {
+						push(m)
+					} else {
+						inbox = nil
+					}
+				case <-quit:
+					return
+				}
+				continue
+			}
+			recv := inbox
+			if len(queue) >= limit {
+				recv = nil // full, only deliver
+			}
+			select {
+			case m, ok := <-recv:
+				if ok {
+					push(m)
+				} else {
+					inbox = nil
+				}
+			case out <- queue[0].msg:
+				heap.Pop(&queue)
+			case <-quit:
+				return
+			}
+		}
+		close(out)
+	}()
+	return out
+}
+
+// queued message on a priority wire, ordered by level first, then by arrival
+type prioItem struct {
+	level int
+	seq   int
+	msg   Message
+}
+
+type priorityQueue []prioItem
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].level != q[j].level {
+		return q[i].level > q[j].level
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(prioItem)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
@@ -0,0 +1,110 @@
+package flow_test
+
+import (
+	"fmt"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleCircuit_ConnectPriority() {
+	g := flow.NewCircuit()
+	g.Add("p", "Pipe")
+	g.Add("c", "Printer")
+	g.ConnectPriority("p.Out", "c.In", 10)
+	g.Feed("c.In", "abc")
+	g.Feed("c.In", "def")
+	g.Feed("c.In", flow.Priority{1, "urgent"})
+	g.Feed("c.In", flow.Priority{2, "very urgent"})
+	g.Run()
+	// Output:
+	// very urgent
+	// urgent
+	// abc
+	// def
+}
+
+type controlled struct {
+	flow.Gadget
+	In flow.Input
+}
+
+func (g *controlled) Run() {
+	for {
+		m, ok := g.Next(g.In)
+		if !ok {
+			break
+		}
+		if c, ok := m.(flow.Control); ok {
+			fmt.Println("control:", c.Msg)
+		} else {
+			fmt.Println("data:", m)
+		}
+	}
+}
+
+func ExampleGadget_Next() {
+	g := flow.NewCircuit()
+	g.AddCircuitry("c", new(controlled))
+	g.SendControl("c", "pause")
+	g.Feed("c.In", "abc")
+	g.Feed("c.In", flow.Control{"stop"})
+	g.Run()
+	// Output:
+	// control: pause
+	// control: stop
+	// data: abc
+}
+
+// a gadget which waits for a given number of control messages
+type waitControl struct {
+	flow.Gadget
+	In flow.Input
+}
+
+func (g *waitControl) Run() {
+	for i := 0; i < 12; i++ {
+		fmt.Println("control:", <-g.Control())
+	}
+}
+
+func ExampleControl() {
+	inner := flow.NewCircuit()
+	inner.AddCircuitry("c", new(waitControl))
+	inner.Label("In", "c.In")
+
+	g := flow.NewCircuit()
+	g.AddCircuitry("sub", inner)
+	for i := 0; i < 12; i++ {
+		g.Feed("sub.In", flow.Control{i})
+	}
+	g.Run()
+	// Output:
+	// control: 0
+	// control: 1
+	// control: 2
+	// control: 3
+	// control: 4
+	// control: 5
+	// control: 6
+	// control: 7
+	// control: 8
+	// control: 9
+	// control: 10
+	// control: 11
+}
+
+func ExampleCircuit_SendControl() {
+	inner := flow.NewCircuit()
+	inner.AddCircuitry("c", new(controlled))
+	inner.Label("In", "c.In")
+
+	g := flow.NewCircuit()
+	g.AddCircuitry("sub", inner)
+	g.SendControl("sub.In", "pause")
+	g.Feed("sub.In", "abc")
+	g.Run()
+	// Output:
+	// control: pause
+	// data: abc
+}
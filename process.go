@@ -0,0 +1,311 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+func init() {
+	Registry["Process"] = func() Circuitry { return new(Process) }
+	Help["Process"] = "Run the command set on Cmd, with JSON messages over stdio."
+}
+
+// Delays before restarting a process which exited while it had input.
+const (
+	processMinBackoff = 100 * time.Millisecond
+	processMaxBackoff = 10 * time.Second
+)
+
+// Longest line read from a process, which is buffered until its newline, so
+// a process which never writes one can't use up all memory.
+const maxProcessLine = 16 << 20
+
+// definition of a gadget which runs as external process, as given in JSON
+// with a "process" entry instead of gadgets and wires
+type processDef struct {
+	Command []string          `json:"command"`
+	Dir     string            `json:"dir,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Restart string            `json:"restart,omitempty"` // see Process
+}
+
+// whether to start the command again after it exited with the given error
+func (d *processDef) restarts(err error) bool {
+	switch d.Restart {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return err != nil // "on-failure", the default
+}
+
+// A Process runs an external command and exchanges messages with it over its
+// standard input and output, one JSON-encoded message per line. A tag is sent
+// as the object {"tag":"...","msg":...}, and such objects are received as
+// tags. Everything the command writes to standard error is logged.
+//
+// If the command fails or can't be started while there is still input, it is
+// restarted after a delay, the message it was handling may then be lost. The "restart" setting
+// of a definition can also be "always", to restart it after a normal exit, or
+// "never". Once the command is not restarted, further input is dropped.
+//
+// The command is set on Cmd as a string with space-separated arguments, or as
+// a list. Definition files can also register a process under its own name,
+// settings such as ${HOME} are expanded in the command, dir, and env values:
+//
+//	"Decode": { "process": { "command": ["python3", "decode.py"] } }
+//
+// Registers as "Process".
+type Process struct {
+	Gadget
+	Cmd Input `flow:"optional"`
+	In  Input `flow:"optional"`
+	Out Output
+
+	def processDef
+}
+
+// a running instance of the command
+type childProcess struct {
+	cmd *exec.Cmd
+	in  chan Message // messages to write to stdin, close to close stdin
+	out chan Message // messages read from stdout, closed on end of file
+}
+
+// Start the command, and restart it whenever it exits before the input ends.
+func (g *Process) Run() {
+	for m := range g.Cmd {
+		switch v := m.(type) {
+		case string:
+			g.def.Command = strings.Fields(v)
+		case []interface{}:
+			g.def.Command = nil
+			for _, arg := range v {
+				g.def.Command = append(g.def.Command, fmt.Sprint(arg))
+			}
+		}
+	}
+	if len(g.def.Command) == 0 {
+		glog.Errorln("no command for process:", g.name)
+		return
+	}
+
+	var (
+		child   *childProcess
+		in      = g.In
+		pending Message
+		queued  bool // set when pending has not been written out yet
+		backoff = processMinBackoff
+	)
+	defer func() {
+		if child != nil && child.cmd.ProcessState == nil {
+			child.cmd.Process.Kill() // the circuit was stopped
+		}
+	}()
+	for {
+		var err error
+		if child, err = g.start(); err != nil {
+			child = nil
+			if !g.def.restarts(err) {
+				glog.Errorln("process", g.name, "did not start, dropping input:", err)
+				for range in {
+				}
+				return
+			}
+			glog.Errorln("process", g.name, err)
+		} else {
+			started := time.Now()
+			for child.out != nil {
+				recv, feed := in, child.in
+				if queued {
+					recv = nil
+				} else {
+					feed = nil
+				}
+				select {
+				case m, ok := <-recv:
+					if !ok {
+						in = nil
+						close(child.in)
+						continue
+					}
+					pending, queued = m, true
+				case feed <- pending:
+					queued = false
+				case m, ok := <-child.out:
+					if !ok {
+						child.out = nil
+						continue
+					}
+					g.Out.Send(m)
+				}
+			}
+			err = child.cmd.Wait()
+			if in == nil {
+				if err != nil {
+					glog.Warningln("process", g.name, err)
+				}
+				return
+			}
+			close(child.in)
+			if !g.def.restarts(err) {
+				glog.Warningln("process", g.name, "exited, dropping input:", err)
+				for range in {
+				}
+				return
+			}
+			glog.Warningln("process", g.name, "exited, restarting:", err)
+			if time.Since(started) > processMaxBackoff {
+				backoff = processMinBackoff
+			}
+		}
+		// wait before restarting, but not if the input ends in the meantime
+		timer := time.After(backoff)
+	wait:
+		for {
+			recv := in
+			if queued {
+				recv = nil
+			}
+			select {
+			case <-timer:
+				break wait
+			case m, ok := <-recv:
+				if !ok {
+					return // nothing left to process
+				}
+				pending, queued = m, true
+			case <-g.Done():
+				return
+			}
+		}
+		if backoff *= 2; backoff > processMaxBackoff {
+			backoff = processMaxBackoff
+		}
+	}
+}
+
+// launch the command, with goroutines to move messages in and out
+func (g *Process) start() (*childProcess, error) {
+	cmd := exec.Command(g.def.Command[0], g.def.Command[1:]...)
+	cmd.Dir = g.def.Dir
+	if len(g.def.Env) > 0 {
+		cmd.Env = os.Environ()
+		for _, k := range sortedKeys(g.def.Env) {
+			cmd.Env = append(cmd.Env, k+"="+g.def.Env[k])
+		}
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	child := &childProcess{
+		cmd: cmd,
+		in:  make(chan Message),
+		out: make(chan Message),
+	}
+	go func() {
+		for m := range child.in {
+			data, err := json.Marshal(plainTags(m))
+			if err != nil {
+				glog.Errorln("process", g.name, "cannot encode:", err)
+				continue
+			}
+			stdin.Write(append(data, '\n')) // errors show up as exit
+		}
+		stdin.Close()
+	}()
+
+	// all reading must be done before calling Wait, see os/exec
+	var logging sync.WaitGroup
+	logging.Add(1)
+	go func() {
+		defer logging.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			glog.Warningf("%s: %s", g.name, scanner.Text())
+		}
+	}()
+	go func() {
+		defer close(child.out)
+		defer logging.Wait()
+		readLines(stdout, func(line []byte) {
+			m, err := decodeProcessLine(line)
+			if err != nil {
+				glog.Errorln("process", g.name, "cannot decode:", err)
+				return
+			}
+			select {
+			case child.out <- m:
+			case <-g.owner.done:
+			}
+		})
+	}()
+	return child, nil
+}
+
+// replace tags by plain objects, which are easier to handle in other languages
+func plainTags(m Message) interface{} {
+	if t, ok := m.(Tag); ok {
+		return map[string]interface{}{"tag": t.Tag, "msg": plainTags(t.Msg)}
+	}
+	return m
+}
+
+// decode a line written by a process, objects with just a tag and a message
+// are turned back into tags
+func decodeProcessLine(line []byte) (Message, error) {
+	var m Message
+	if err := json.Unmarshal(line, &m); err != nil {
+		return nil, err
+	}
+	return tagOf(m), nil
+}
+
+func tagOf(m Message) Message {
+	if obj, ok := m.(map[string]interface{}); ok && len(obj) == 2 {
+		tag, ok := obj["tag"].(string)
+		if msg, found := obj["msg"]; ok && found {
+			return Tag{tag, tagOf(msg)}
+		}
+	}
+	return m
+}
+
+// call a function for each non-empty line, until end of file or a read error
+func readLines(r io.Reader, fn func(line []byte)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxProcessLine)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(bytes.TrimSpace(line)) > 0 {
+			fn(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		io.Copy(ioutil.Discard, r) // keep the process from blocking on output
+	}
+}
@@ -0,0 +1,95 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jcw/flow"
+)
+
+func ExampleProcess() {
+	g := flow.NewCircuit()
+	g.Add("p", "Process")
+	g.Feed("p.Cmd", "cat")
+	g.Feed("p.In", "abc")
+	g.Feed("p.In", flow.Tag{"a", []interface{}{1, true}})
+	g.Run()
+	// Output:
+	// Lost string: abc
+	// Lost flow.Tag: {a [1 true]}
+}
+
+func ExampleProcess_definition() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(name, []byte(`{
+		"Greet": { "process": {
+			"command": ["sh", "-c", "while read m; do echo \"\\\"$HI\\\"\"; done"],
+			"env": { "HI": "hello" }
+		} },
+		"Once": { "process": {
+			"command": ["sh", "-c", "read m; echo '\"${WORD}\"'"]
+		} }
+	}`), 0666)
+	flow.Check(flow.AddToRegistry(name))
+	flow.Config["WORD"] = "once"
+	defer delete(flow.Config, "WORD")
+
+	g := flow.NewCircuit()
+	g.Add("g", "Greet")
+	g.Feed("g.In", 1)
+	g.Feed("g.In", 2)
+	g.Run()
+
+	// a program which exits normally is not restarted
+	g = flow.NewCircuit()
+	g.Add("o", "Once")
+	g.Feed("o.In", 1)
+	g.Feed("o.In", 2)
+	g.Run()
+	// Output:
+	// Lost string: hello
+	// Lost string: hello
+	// Lost string: once
+}
+
+func ExampleProcess_startFailure() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(name, []byte(`{
+		"Missing": { "process": {
+			"command": ["/no/such/command"], "restart": "never"
+		} },
+		"Retried": { "process": { "command": ["/no/such/command"] } }
+	}`), 0666)
+	flow.Check(flow.AddToRegistry(name))
+
+	// a command which can't be started is not retried with "never"
+	g := flow.NewCircuit()
+	g.Add("m", "Missing")
+	g.Feed("m.In", 1)
+	g.Feed("m.In", 2)
+	g.Run()
+
+	// otherwise it is retried, until the circuit is stopped
+	g = flow.NewCircuit()
+	g.Add("r", "Retried")
+	g.Feed("r.In", 1)
+	g.Feed("r.In", 2)
+	done := make(chan struct{})
+	go func() {
+		g.Run()
+		close(done)
+	}()
+	time.Sleep(250 * time.Millisecond)
+	g.Stop()
+	<-done
+	fmt.Println("stopped")
+	// Output:
+	// stopped
+}
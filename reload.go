@@ -0,0 +1,409 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	liveMutex    sync.Mutex
+	liveCircuits = map[*Circuit]bool{} // running circuits created from JSON
+)
+
+// keep track of which registered circuits are currently running
+func trackLive(c *Circuit, running bool) {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+	if running {
+		liveCircuits[c] = true
+	} else {
+		delete(liveCircuits, c)
+	}
+}
+
+// WatchRegistry polls a definitions file and calls ReloadRegistry whenever it
+// changes. Failed reloads are logged, the previous definitions then stay in
+// effect. Close the returned channel to stop watching.
+func WatchRegistry(filename string, interval time.Duration) chan struct{} {
+	stop := make(chan struct{})
+	last, _ := ioutil.ReadFile(filename)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				data, err := ioutil.ReadFile(filename)
+				if err != nil || bytes.Equal(data, last) {
+					continue
+				}
+				last = data
+				if err := ReloadRegistry(filename); err != nil {
+					glog.Errorln("reload failed:", err)
+				} else {
+					glog.Infoln("reloaded:", filename)
+				}
+			}
+		}
+	}()
+	return stop
+}
+
+// ReloadRegistry re-reads a definitions file and updates the registry. Running
+// circuits created from a changed definition are patched in place: gadgets
+// which are new or whose type, params, feeds, or wires changed are replaced, all other
+// gadgets keep running with their state and queued messages. Replaced gadgets
+// get to finish processing whatever is already queued for them. Definitions
+// which are no longer in the file are removed from the registry, circuits
+// already running keep going. Nothing is changed if any of the definitions in
+// the file fails validation.
+func ReloadRegistry(filename string) error {
+	set, err := loadDefinitions(filename, nil)
+	if err != nil {
+		return err
+	}
+	definitions := set.defs
+	confs := map[string]*config{}
+	for name, def := range definitions {
+		conf, err := parseDefinition(def, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		confs[name] = conf
+	}
+	for name, conf := range confs {
+		if err := conf.validate(confs); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	patches := map[*Circuit]*config{}
+	for c := range liveCircuits {
+		def, ok := definitions[c.defName]
+		if !ok {
+			continue
+		}
+		conf, err := parseDefinition(def, c.params)
+		if err != nil {
+			return fmt.Errorf("%s: %s", c.defName, err)
+		}
+		if !reflect.DeepEqual(conf, c.loaded) {
+			if err := c.canPatch(conf); err != nil {
+				return fmt.Errorf("%s: %s", c.defName, err)
+			}
+			patches[c] = conf
+		}
+	}
+
+	// everything checks out, now commit to the new definitions
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if err := set.checkRegistered(filename); err != nil {
+		return err
+	}
+	for _, name := range defFiles[filename] {
+		if _, ok := definitions[name]; !ok {
+			delete(Registry, name)
+			delete(circuitDefs, name)
+			delete(defOrigins, name)
+		}
+	}
+	for name, def := range definitions {
+		registerCircuit(name, def)
+		defOrigins[name] = set.origin[name]
+	}
+	defFiles[filename] = sortedKeys(definitions)
+	for c, conf := range patches {
+		c.patch(conf)
+	}
+	return nil
+}
+
+// check that a definition is self-consistent and that all gadgets and pins
+// exist, the defs map has all definitions which are about to be registered
+func (conf *config) validate(defs map[string]*config) error {
+	// pins are looked up as for linting, without instantiating any circuits
+	l := &linter{
+		registry: Registry,
+		defs:     defs,
+		pins:     map[string]map[string]pinInfo{},
+	}
+	types := map[string]string{}
+	for _, g := range conf.Gadgets {
+		if g.Name == "" || strings.Contains(g.Name, ".") {
+			return fmt.Errorf("invalid gadget name: %q", g.Name)
+		}
+		if _, ok := types[g.Name]; ok {
+			return fmt.Errorf("duplicate gadget name: %s", g.Name)
+		}
+		if defs[g.Type] == nil && Registry[g.Type] == nil {
+			return fmt.Errorf("unknown gadget type: %s", g.Type)
+		}
+		if g.Params != nil {
+			def := defs[g.Type]
+			if def == nil && circuitDefs[g.Type] != nil {
+				def, _ = parseDefinition(circuitDefs[g.Type], nil)
+			}
+			if def == nil {
+				return fmt.Errorf("no parameters allowed for: %s", g.Type)
+			}
+			for _, k := range sortedKeys(g.Params) {
+				if _, ok := def.Params[k]; !ok {
+					return fmt.Errorf("unknown parameter for %s: %s", g.Type, k)
+				}
+			}
+		}
+		types[g.Name] = g.Type
+	}
+
+	checkPin := func(pin string) error {
+		if !strings.Contains(pin, ".") {
+			return fmt.Errorf("pin must be of the form gadget.pin: %s", pin)
+		}
+		typ, ok := types[gadgetPart(pin)]
+		if !ok {
+			return fmt.Errorf("gadget not found for: %s", pin)
+		}
+		name := strings.Split(pinPart(pin), ":")[0]
+		if _, ok := l.pinsOfType(typ)[name]; !ok {
+			return fmt.Errorf("pin not found: %s", pin)
+		}
+		return nil
+	}
+
+	for _, w := range conf.Wires {
+		if err := checkPin(w.From); err != nil {
+			return err
+		}
+		if err := checkPin(w.To); err != nil {
+			return err
+		}
+		if w.Journal != "" && Codecs[w.Codec] == nil && w.Codec != "" {
+			return fmt.Errorf("codec not found: %s", w.Codec)
+		}
+	}
+	for _, f := range conf.Feeds {
+		if err := checkPin(f.To); err != nil {
+			return err
+		}
+		if err := checkFeed(types[gadgetPart(f.To)], f); err != nil {
+			return fmt.Errorf("bad feed for %s: %s", f.To, err)
+		}
+	}
+	for _, l := range conf.Labels {
+		if strings.Contains(l.External, ".") {
+			return fmt.Errorf("external pin should not include a dot: %s",
+				l.External)
+		}
+		if err := checkPin(l.Internal); err != nil {
+			return err
+		}
+	}
+	for _, r := range conf.Remotes {
+		if (r.From == "") == (r.To == "") {
+			return fmt.Errorf("remote needs either from or to: %s", r.Addr)
+		}
+		if err := checkPin(r.From + r.To); err != nil {
+			return err
+		}
+		if Codecs[r.Codec] == nil && r.Codec != "" {
+			return fmt.Errorf("codec not found: %s", r.Codec)
+		}
+	}
+	return nil
+}
+
+// run the feed check for the pin a feed goes to, if there is one
+func checkFeed(typ string, f feedDef) error {
+	check := FeedChecks[typ+"."+strings.Split(pinPart(f.To), ":")[0]]
+	if check == nil {
+		return nil
+	}
+	return check(f.Data)
+}
+
+var (
+	inputType     = reflect.TypeOf(Input(nil))
+	outputType    = reflect.TypeOf((*Output)(nil)).Elem()
+	outputMapType = reflect.TypeOf(map[string]Output(nil))
+)
+
+// determine which gadgets in the new definition need to be (re-)created
+func (c *Circuit) changedGadgets(conf *config) map[string]bool {
+	old := c.loaded
+	if old == nil {
+		old = &config{}
+	}
+	incident := func(conf *config) map[string][]interface{} {
+		m := map[string][]interface{}{}
+		for _, w := range conf.Wires {
+			m[gadgetPart(w.From)] = append(m[gadgetPart(w.From)], w)
+			m[gadgetPart(w.To)] = append(m[gadgetPart(w.To)], w)
+		}
+		for _, f := range conf.Feeds {
+			m[gadgetPart(f.To)] = append(m[gadgetPart(f.To)], f)
+		}
+		return m
+	}
+	oldDefs := map[string]gadgetDef{}
+	for _, g := range old.Gadgets {
+		oldDefs[g.Name] = g
+	}
+	oldUses, newUses := incident(old), incident(conf)
+
+	changed := map[string]bool{}
+	for _, g := range conf.Gadgets {
+		if !reflect.DeepEqual(oldDefs[g.Name], g) ||
+			!reflect.DeepEqual(oldUses[g.Name], newUses[g.Name]) {
+			changed[g.Name] = true
+		}
+	}
+	return changed
+}
+
+// check whether a running circuit can be patched to a new definition
+func (c *Circuit) canPatch(conf *config) error {
+	changed := c.changedGadgets(conf)
+	wires := append([]wireDef{}, conf.Wires...)
+	if c.loaded != nil {
+		wires = append(wires, c.loaded.Wires...)
+	}
+	for _, w := range wires {
+		if w.Journal != "" && changed[gadgetPart(w.To)] {
+			return fmt.Errorf("durable wire needs a restart: %s", w.To)
+		}
+	}
+	return nil
+}
+
+// replace changed gadgets and wires in a running circuit
+func (c *Circuit) patch(conf *config) {
+	changed := c.changedGadgets(conf)
+	c.lock.Lock()
+
+	// take all changed and removed gadgets out of the circuit
+	keep := map[string]bool{}
+	for _, g := range conf.Gadgets {
+		keep[g.Name] = !changed[g.Name]
+	}
+	retired := map[string]*Gadget{}
+	for _, g := range c.gnames {
+		if !keep[g.Name] {
+			retired[g.Name] = c.gadgets[g.Name]
+			delete(c.gadgets, g.Name)
+		}
+	}
+
+	// rebuild the definition, creating only the changed gadgets
+	c.gnames = nil
+	c.wires = nil
+	c.feeds = map[string][]Message{}
+	for _, g := range conf.Gadgets {
+		if !changed[g.Name] {
+			c.gnames = append(c.gnames, g)
+		} else if err := c.add(g); err != nil {
+			glog.Errorln(err)
+		}
+	}
+
+	// replacement gadgets take over the wires still going to the same pins,
+	// all other inputs of retired gadgets are closed to let them finish
+	used := map[string]bool{}
+	for _, w := range conf.Wires {
+		used[w.To] = true
+	}
+	for _, f := range conf.Feeds {
+		used[f.To] = true
+	}
+	handovers := map[*wire]*handover{}
+	var unused []*wire
+	for name, old := range retired {
+		g := c.gadgets[name] // nil if the gadget was removed
+		old.mutex.Lock()
+		old.retired = true
+		for pin, w := range old.inputs {
+			if g != nil && used[name+"."+pin] {
+				delete(old.inputs, pin)
+				g.inputs[pin] = w // g is not reachable until the handover
+				handovers[w] = &handover{dest: g, priority: w.priority}
+			} else {
+				unused = append(unused, w)
+			}
+		}
+		old.mutex.Unlock()
+	}
+
+	for _, w := range conf.Wires {
+		c.wires = append(c.wires, w)
+		from, to := gadgetPart(w.From), gadgetPart(w.To)
+		if changed[from] || changed[to] {
+			in := c.lookupGadget(w.To).getInput(pinPart(w.To), w.Capacity)
+			if h := handovers[in]; h != nil {
+				h.priority = w.Priority // senders still use it
+			} else {
+				in.priority = w.Priority
+			}
+			if changed[from] {
+				c.lookupGadget(w.From).setOutput(pinPart(w.From), in)
+			}
+		}
+	}
+	for _, f := range conf.Feeds {
+		c.Feed(f.To, f.message())
+	}
+	if !reflect.DeepEqual(conf.Labels, c.loaded.Labels) {
+		glog.Warningln("labels changed, existing connections are kept:",
+			c.defName)
+	}
+	c.labels = map[string]string{}
+	for _, l := range conf.Labels {
+		c.Label(l.External, l.Internal)
+	}
+	c.loaded = conf
+	c.lock.Unlock()
+
+	// now send to the new gadgets, which close the previous channels once
+	// they start, and wait for sends in progress to close unused inputs
+	for w, h := range handovers {
+		w.mutex.Lock()
+		w.dest, w.priority, w.handover = h.dest, h.priority, true
+		w.publish()
+		w.mutex.Unlock()
+	}
+	for _, w := range unused {
+		w.mutex.Lock()
+		w.senders = 0 // so that Disconnect won't close it again
+		w.closeChannel()
+		w.mutex.Unlock()
+	}
+
+	for _, g := range conf.Gadgets {
+		if changed[g.Name] {
+			glog.Infoln("replacing gadget:", c.defName+"."+g.Name)
+			c.lock.RLock()
+			gadget := c.gadgets[g.Name]
+			c.lock.RUnlock()
+			if gadget != nil { // nil if it could not be added
+				gadget.launch()
+			}
+		}
+	}
+}
+
+// a wire which is about to be taken over by a replacement gadget
+type handover struct {
+	dest     *Gadget
+	priority bool
+}
@@ -0,0 +1,225 @@
+package flow_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+var sourceChannel chan flow.Message
+
+// a gadget which sends out whatever arrives on sourceChannel
+type source struct {
+	flow.Gadget
+	Out flow.Output
+}
+
+func (g *source) Run() {
+	for m := range sourceChannel {
+		g.Out.Send(m)
+	}
+}
+
+func init() {
+	flow.Registry["testSource"] = func() flow.Circuitry { return new(source) }
+}
+
+const reloadBefore = `{"reloaded": {
+	"gadgets": [
+		{"name": "s", "type": "testSource"},
+		{"name": "p", "type": "Pipe"},
+		{"name": "c", "type": "Counter"},
+		{"name": "out", "type": "Printer"}
+	],
+	"wires": [
+		{"from": "s.Out", "to": "p.In"},
+		{"from": "p.Out", "to": "c.In"},
+		{"from": "c.Out", "to": "out.In"}
+	]
+}, "extra": {
+	"gadgets": [
+		{"name": "p", "type": "Pipe"}
+	]
+}}`
+
+const reloadAfter = `{"reloaded": {
+	"gadgets": [
+		{"name": "s", "type": "testSource"},
+		{"name": "p", "type": "Delay"},
+		{"name": "c", "type": "Counter"},
+		{"name": "out", "type": "Printer"}
+	],
+	"wires": [
+		{"from": "s.Out", "to": "p.In"},
+		{"from": "p.Out", "to": "c.In"},
+		{"from": "c.Out", "to": "out.In"}
+	],
+	"feeds": [
+		{"data": "1ms", "to": "p.Delay"}
+	]
+}}`
+
+// remove the definitions of a file from the registry again, so that another
+// run of the same test can add them from a new file
+func unregister(filename string) {
+	ioutil.WriteFile(filename, []byte("{}"), 0666)
+	flow.ReloadRegistry(filename)
+}
+
+func ExampleReloadRegistry() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	setup := filepath.Join(dir, "setup.json")
+	ioutil.WriteFile(setup, []byte(reloadBefore), 0666)
+	flow.AddToRegistry(setup)
+	defer unregister(setup)
+	sourceChannel = make(chan flow.Message)
+
+	c := flow.Registry["reloaded"]().(*flow.Circuit)
+	seen, untap, _ := c.Tap("c.In", 10)
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+	sourceChannel <- "abc"
+	sourceChannel <- "def"
+	fmt.Println(<-seen, <-seen)
+
+	// replace the pipe by a delay, the counter keeps its count
+	ioutil.WriteFile(setup, []byte(reloadAfter), 0666)
+	fmt.Println(flow.ReloadRegistry(setup))
+	for _, g := range c.Status().Gadgets {
+		if g.Name == "p" {
+			fmt.Println(g.Type)
+		}
+	}
+	_, ok := flow.Registry["extra"]
+	fmt.Println(ok)
+
+	sourceChannel <- "ghi"
+	fmt.Println(<-seen)
+	untap()
+	close(sourceChannel)
+	<-done
+	// Output:
+	// abc def
+	// <nil>
+	// Delay
+	// false
+	// ghi
+	// 3
+}
+
+var (
+	floodStop chan struct{}     // closed to end the flood
+	floodSent int64             // number of messages sent by the flood
+	floodSeen chan flow.Message // the final count, as reported
+)
+
+// a gadget which keeps sending messages until floodStop is closed
+type flood struct {
+	flow.Gadget
+	Out flow.Output
+}
+
+func (g *flood) Run() {
+	for i := 0; ; i++ {
+		select {
+		case <-floodStop:
+			return
+		default:
+		}
+		g.Out.Send(i)
+		atomic.AddInt64(&floodSent, 1)
+	}
+}
+
+// a gadget which passes on each message, as alternative for a Pipe
+type relay struct {
+	flow.Gadget
+	In  flow.Input
+	Out flow.Output
+}
+
+func (g *relay) Run() {
+	for m := range g.In {
+		g.Out.Send(m)
+	}
+}
+
+// a gadget which reports whatever arrives on floodSeen
+type report struct {
+	flow.Gadget
+	In flow.Input
+}
+
+func (g *report) Run() {
+	for m := range g.In {
+		floodSeen <- m
+	}
+}
+
+func init() {
+	flow.Registry["testFlood"] = func() flow.Circuitry { return new(flood) }
+	flow.Registry["testRelay"] = func() flow.Circuitry { return new(relay) }
+	flow.Registry["testReport"] = func() flow.Circuitry { return new(report) }
+}
+
+const reloadBusy = `{"busy": {
+	"gadgets": [
+		{"name": "s", "type": "testFlood"},
+		{"name": "p", "type": "%s"},
+		{"name": "c", "type": "Counter"},
+		{"name": "r", "type": "testReport"}
+	],
+	"wires": [
+		{"from": "s.Out", "to": "p.In", "capacity": %d},
+		{"from": "p.Out", "to": "c.In"},
+		{"from": "c.Out", "to": "r.In"}
+	]
+}}`
+
+func TestReloadRegistry_busy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	setup := filepath.Join(dir, "busy.json")
+	ioutil.WriteFile(setup, []byte(fmt.Sprintf(reloadBusy, "Pipe", 10)), 0666)
+	if err := flow.AddToRegistry(setup); err != nil {
+		t.Fatal(err)
+	}
+	defer unregister(setup)
+	floodStop = make(chan struct{})
+	floodSeen = make(chan flow.Message, 1)
+	atomic.StoreInt64(&floodSent, 0)
+
+	c := flow.Registry["busy"]().(*flow.Circuit)
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+
+	// swap the gadget in the middle back and forth, while messages flow
+	for i := 0; i < 20; i++ {
+		typ := []string{"testRelay", "Pipe"}[i%2]
+		def := fmt.Sprintf(reloadBusy, typ, i%3*5)
+		ioutil.WriteFile(setup, []byte(def), 0666)
+		if err := flow.ReloadRegistry(setup); err != nil {
+			t.Fatal(err)
+		}
+		c.Status()
+	}
+	close(floodStop)
+	<-done
+
+	if n := <-floodSeen; n != int(atomic.LoadInt64(&floodSent)) {
+		t.Fatalf("counted %v, sent %d", n, floodSent)
+	}
+}
@@ -0,0 +1,306 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// definition of one link to or from another process
+type remoteDef struct {
+	From   string `json:"from,omitempty"` // local output pin, to send out
+	To     string `json:"to,omitempty"`   // local input pin, to receive on
+	Addr   string `json:"addr"`           // "tcp://host:port" or "unix:///path"
+	Codec  string `json:"codec,omitempty"`
+	Window int    `json:"window,omitempty"` // messages in flight, receiver only
+}
+
+// Frames sent over a link, these use the same layout as journal records.
+const (
+	linkSession = 'S' // sender to receiver: session id, as seq
+	linkMessage = 'M' // sender to receiver: encoded message, with its seq
+	linkEnd     = 'E' // sender to receiver: no more messages will follow
+	linkWindow  = 'W' // receiver to sender: number of messages in flight
+	linkAck     = 'A' // receiver to sender: seq of last delivered message
+)
+
+// Delays between attempts to (re-)connect a link.
+const (
+	linkMinBackoff = 50 * time.Millisecond
+	linkMaxBackoff = 5 * time.Second
+)
+
+// split "tcp://host:port" or "unix:///path" into network and address
+func splitAddr(addr string) (string, string) {
+	if p := strings.SplitN(addr, "://", 2); len(p) == 2 {
+		return p[0], p[1]
+	}
+	return "tcp", addr
+}
+
+// SendRemote sends all messages from an output pin to another process, which
+// must receive them with ReceiveRemote on the same address. The link is
+// re-established when lost, messages which were not yet acknowledged are sent
+// again and the receiver weeds out duplicates. The receiver hands out credit
+// for a limited number of messages in flight, a slow receiver therefore
+// holds up the sending gadget, as with a local wire.
+func (c *Circuit) SendRemote(from, addr, codec string) {
+	c.remotes = append(c.remotes, remoteDef{From: from, Addr: addr,
+		Codec: codec})
+	name := fmt.Sprintf("@remote%d", len(c.remotes))
+	c.AddCircuitry(name, &remoteSender{addr: addr, codec: codecOf(codec)})
+	w := c.gadgets[name].getInput("In", 0)
+	c.gadgetOf(from).setOutput(pinPart(from), w)
+}
+
+// ReceiveRemote listens on an address for messages sent with SendRemote, and
+// passes them on to an input pin. The window is the number of messages the
+// sender may have in flight. The listener ends once the sender is done.
+func (c *Circuit) ReceiveRemote(addr, to, codec string, window int) {
+	c.remotes = append(c.remotes, remoteDef{To: to, Addr: addr, Codec: codec,
+		Window: window})
+	if window < 1 {
+		window = 1
+	}
+	name := fmt.Sprintf("@remote%d", len(c.remotes))
+	c.AddCircuitry(name, &remoteReceiver{addr: addr, codec: codecOf(codec),
+		window: window})
+	w := c.gadgetOf(to).getInput(pinPart(to), 0)
+	c.gadgets[name].setOutput("Out", w)
+}
+
+// the sending end of a link
+type remoteSender struct {
+	Gadget
+	In Input
+
+	addr  string
+	codec Codec
+}
+
+// a frame received over a link, tagged with the connection it came from
+type linkEvent struct {
+	conn net.Conn
+	kind byte
+	seq  uint64
+	err  error
+}
+
+func (g *remoteSender) Run() {
+	type sent struct {
+		seq  uint64
+		data []byte
+	}
+	var (
+		conn    net.Conn
+		w       *bufio.Writer
+		events  = make(chan linkEvent)
+		seq     uint64
+		pending []sent // sent or to be sent, not yet acknowledged
+		flight  int    // number of pending messages sent on this connection
+		window  int    // number of messages allowed in flight
+		in      = g.In
+		backoff = linkMinBackoff
+	)
+	session := uint64(time.Now().UnixNano()) // lets the receiver spot restarts
+	done := make(chan struct{})
+	defer close(done)
+
+	// write errors need no checking, they also show up as read errors
+	send := func(kind byte, seq uint64, data []byte) {
+		if writeJournalRecord(w, kind, seq, data) == nil {
+			w.Flush()
+		}
+	}
+	for {
+		if conn == nil {
+			network, address := splitAddr(g.addr)
+			var err error
+			if conn, err = net.Dial(network, address); err != nil {
+				glog.Warningln("remote link:", err)
+				conn = nil
+				select {
+				case <-time.After(backoff):
+				case <-g.Done():
+					return // stopped before the link was up
+				}
+				if backoff *= 2; backoff > linkMaxBackoff {
+					backoff = linkMaxBackoff
+				}
+				continue
+			}
+			backoff = linkMinBackoff
+			w = bufio.NewWriter(conn)
+			flight, window = 0, 0
+			go readLink(conn, events, done)
+			send(linkSession, session, nil)
+		}
+
+		// send whatever is pending and allowed, then wait for progress
+		for flight < len(pending) && flight < window {
+			send(linkMessage, pending[flight].seq, pending[flight].data)
+			flight++
+		}
+		if in == nil && len(pending) == 0 {
+			send(linkEnd, 0, nil)
+			conn.Close()
+			return
+		}
+		recv := in
+		if len(pending) >= window {
+			recv = nil // out of credit, leave messages queued on the wire
+		}
+		select {
+		case <-g.Done():
+			conn.Close()
+			return
+		case m, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			data, err := g.codec.Encode(m)
+			if err != nil {
+				glog.Errorln("remote link, cannot encode:", err)
+				continue
+			}
+			seq++
+			pending = append(pending, sent{seq, data})
+		case ev := <-events:
+			if ev.conn != conn {
+				continue // left over from a previous connection
+			}
+			switch {
+			case ev.err != nil:
+				glog.Warningln("remote link lost:", ev.err)
+				conn.Close()
+				conn = nil
+			case ev.kind == linkWindow:
+				window = int(ev.seq)
+			case ev.kind == linkAck:
+				for len(pending) > 0 && pending[0].seq <= ev.seq {
+					pending = pending[1:]
+					flight--
+				}
+			}
+		}
+	}
+}
+
+// pass all frames arriving on a connection to the events channel
+func readLink(conn net.Conn, events chan<- linkEvent, done chan struct{}) {
+	r := bufio.NewReader(conn)
+	for {
+		kind, seq, _, err := readJournalRecord(r)
+		select {
+		case events <- linkEvent{conn, kind, seq, err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// the receiving end of a link
+type remoteReceiver struct {
+	Gadget
+	Out Output
+
+	addr   string
+	codec  Codec
+	window int
+}
+
+func (g *remoteReceiver) Run() {
+	network, address := splitAddr(g.addr)
+	if network == "unix" {
+		os.Remove(address) // clean up after a previous run
+	}
+	ln, err := net.Listen(network, address)
+	Check(err)
+	defer ln.Close()
+
+	// when stopped, close the listener and the current connection
+	var mutex sync.Mutex
+	var conn net.Conn
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-g.Done():
+			mutex.Lock()
+			ln.Close()
+			if conn != nil {
+				conn.Close()
+			}
+			mutex.Unlock()
+		case <-finished:
+		}
+	}()
+
+	var session, delivered uint64
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-g.Done():
+			default:
+				glog.Errorln("remote link:", err)
+			}
+			return
+		}
+		mutex.Lock()
+		conn = c
+		mutex.Unlock()
+
+		// a bad frame only drops this connection, the sender will reconnect
+		r := bufio.NewReader(c)
+		w := bufio.NewWriter(c)
+		reply := func(kind byte, seq uint64) error {
+			if err := writeJournalRecord(w, kind, seq, nil); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		err = reply(linkWindow, uint64(g.window))
+		for err == nil {
+			var kind byte
+			var seq uint64
+			var data []byte
+			if kind, seq, data, err = readJournalRecord(r); err != nil {
+				break
+			}
+			switch kind {
+			case linkSession:
+				if seq != session {
+					session, delivered = seq, 0 // a new sender
+				}
+			case linkMessage:
+				if seq > delivered {
+					// a message which can't be decoded would be sent again
+					// and again, so it is acked and dropped
+					if m, err := g.codec.Decode(data); err != nil {
+						glog.Errorln("remote link, dropped message", seq, err)
+					} else {
+						g.Out.Send(m)
+					}
+					delivered = seq
+				}
+				err = reply(linkAck, seq)
+			case linkEnd:
+				c.Close()
+				return
+			}
+		}
+		glog.Warningln("remote link lost:", err)
+		c.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package flow_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func ExampleCircuit_SendRemote() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	addr := "unix://" + filepath.Join(dir, "link")
+
+	// the sender starts first, and keeps retrying until the receiver is up
+	sender := flow.NewCircuit()
+	sender.Add("p", "Pipe")
+	sender.SendRemote("p.Out", addr, "")
+	sender.Feed("p.In", "abc")
+	sender.Feed("p.In", 123)
+	sender.Feed("p.In", []string{"def"})
+	done := make(chan struct{})
+	go func() {
+		sender.Run()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	receiver := flow.NewCircuit()
+	err := receiver.LoadJSON([]byte(`{
+		"gadgets": [{ "name": "p", "type": "Printer" }],
+		"remotes": [{ "addr": "` + addr + `", "to": "p.In", "window": 1 }]
+	}`))
+	fmt.Println(err)
+	receiver.Run()
+	<-done
+	// Output:
+	// <nil>
+	// abc
+	// 123
+	// [def]
+}
+
+func ExampleCircuit_ReceiveRemote() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "link")
+
+	receiver := flow.NewCircuit()
+	receiver.Add("p", "Printer")
+	receiver.ReceiveRemote("unix://"+path, "p.In", "", 1)
+	done := make(chan struct{})
+	go func() {
+		receiver.Run()
+		close(done)
+	}()
+
+	// a frame announcing a huge message only drops that connection
+	conn, err := net.Dial("unix", path)
+	for err != nil {
+		time.Sleep(time.Millisecond) // until the receiver is listening
+		conn, err = net.Dial("unix", path)
+	}
+	conn.Write([]byte{'M', 1, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F})
+	_, err = ioutil.ReadAll(conn)
+	fmt.Println(err)
+	conn.Close()
+
+	sender := flow.NewCircuit()
+	sender.Add("p", "Pipe")
+	sender.SendRemote("p.Out", "unix://"+path, "")
+	sender.Feed("p.In", "still here")
+	sender.Run()
+	<-done
+	// Output:
+	// <nil>
+	// still here
+}
+
+func ExampleCircuit_ReceiveRemote_badMessage() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "link")
+
+	receiver := flow.NewCircuit()
+	receiver.Add("p", "Printer")
+	receiver.ReceiveRemote("unix://"+path, "p.In", "", 1)
+	done := make(chan struct{})
+	go func() {
+		receiver.Run()
+		close(done)
+	}()
+
+	// a message which can't be decoded is acked, and the link stays up
+	conn, err := net.Dial("unix", path)
+	for err != nil {
+		time.Sleep(time.Millisecond) // until the receiver is listening
+		conn, err = net.Dial("unix", path)
+	}
+	reply := make([]byte, 3)
+	io.ReadFull(conn, reply) // window
+	conn.Write([]byte{'M', 1, 3, '{', '{', '{'})
+	io.ReadFull(conn, reply)
+	fmt.Printf("%c %d\n", reply[0], reply[1])
+	conn.Write([]byte{'M', 2, 4, '"', 'o', 'k', '"'})
+	io.ReadFull(conn, reply)
+	conn.Write([]byte{'E', 0, 0})
+	<-done
+	conn.Close()
+	fmt.Printf("%c %d\n", reply[0], reply[1])
+	// Output:
+	// A 1
+	// ok
+	// A 2
+}
+
+func ExampleCircuit_SendRemote_stop() {
+	// a sender which cannot reach its receiver ends when stopped
+	sender := flow.NewCircuit()
+	sender.Add("p", "Pipe")
+	sender.SendRemote("p.Out", "unix:///nonexistent/link", "")
+	sender.Feed("p.In", "lost")
+	done := make(chan struct{})
+	go func() {
+		sender.Run()
+		close(done)
+	}()
+	sender.Stop()
+	<-done
+	fmt.Println("stopped")
+	// Output:
+	// stopped
+}
@@ -0,0 +1,197 @@
+package flow
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema returns a JSON Schema describing circuit definition files, as read
+// by AddToRegistry, ready to be encoded with json.Marshal. If a registry is
+// given, the schema is stricter: gadget types must be registry entries and
+// wires must refer to existing input and output pin names. Since a schema can
+// not relate gadget names to their types, pins are checked against the pins of
+// all types combined. Keys are matched regardless of case, as in LoadJSON.
+func Schema(registry map[string]func() Circuitry) map[string]interface{} {
+	pin := map[string]interface{}{
+		"type":    "string",
+		"pattern": `^[^.]+\.[^.]+$`,
+	}
+	from, to := pin, pin
+	gadgetType := map[string]interface{}{
+		"type":        "string",
+		"description": "registry entry to create the gadget from",
+	}
+	definitions := map[string]interface{}{}
+
+	if registry != nil {
+		types := []string{}
+		for name := range registry {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		gadgetType["enum"] = types
+
+		inputs, outputs := map[string]bool{}, map[string]bool{}
+		for _, e := range Catalog(registry) { // doesn't instantiate circuits
+			for _, p := range e.Inputs {
+				inputs[p.Name] = true
+			}
+			for _, p := range e.Outputs {
+				if p.Map {
+					outputs[p.Name+":"] = true
+				} else {
+					outputs[p.Name] = true
+				}
+			}
+		}
+		from = pinSchema(outputs, "output pin, as gadget.Pin or gadget.Pin:name")
+		to = pinSchema(inputs, "input pin, as gadget.Pin")
+	}
+
+	str := map[string]interface{}{"type": "string"}
+	object := func(required []string, props map[string]interface{}) interface{} {
+		obj := caseless(props)
+		obj["additionalProperties"] = false
+		// "required" is case-sensitive, so check that some key matches
+		musts := []interface{}{}
+		for _, name := range required {
+			musts = append(musts, map[string]interface{}{
+				"not": map[string]interface{}{
+					"propertyNames": map[string]interface{}{
+						"not": map[string]interface{}{"pattern": keyPattern(name)},
+					},
+				},
+			})
+		}
+		if len(musts) > 0 {
+			obj["allOf"] = musts
+		}
+		return obj
+	}
+	list := func(item interface{}) interface{} {
+		return map[string]interface{}{"type": "array", "items": item}
+	}
+
+	definitions["circuit"] = caseless(map[string]interface{}{
+		"params": map[string]interface{}{
+			"type":        "object",
+			"description": "parameters, with their default values",
+		},
+		"gadgets": list(object([]string{"name", "type"},
+			map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":    "string",
+					"pattern": `^[^.]+$`,
+				},
+				"type":   gadgetType,
+				"params": map[string]interface{}{"type": "object"},
+			})),
+		"wires": list(object([]string{"from", "to"},
+			map[string]interface{}{
+				"from": from,
+				"to":   to,
+				"capacity": map[string]interface{}{
+					"type":    "integer",
+					"minimum": 0,
+				},
+				"priority": map[string]interface{}{"type": "boolean"},
+				"journal":  str,
+				"codec":    str,
+			})),
+		"feeds": list(object([]string{"to"},
+			map[string]interface{}{
+				"tag":  str,
+				"data": map[string]interface{}{},
+				"to":   to,
+			})),
+		"remotes": list(object([]string{"addr"},
+			map[string]interface{}{
+				"from":   from,
+				"to":     to,
+				"addr":   str,
+				"codec":  str,
+				"window": map[string]interface{}{"type": "integer"},
+			})),
+		"labels": list(object([]string{"external", "internal"},
+			map[string]interface{}{
+				"external": map[string]interface{}{
+					"type":    "string",
+					"pattern": `^[^.]+$`,
+				},
+				"internal": pin,
+			})),
+		"process": object([]string{"command"},
+			map[string]interface{}{
+				"command": list(str),
+				"dir":     str,
+				"env": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": str,
+				},
+				"restart": map[string]interface{}{
+					"enum": []string{"on-failure", "always", "never"},
+				},
+			}),
+	})
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "Flow circuit definitions",
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"$ref": "#/definitions/circuit"},
+		"properties": map[string]interface{}{
+			"imports": list(map[string]interface{}{
+				"oneOf": []interface{}{str, object([]string{"path"},
+					map[string]interface{}{"path": str, "as": str})},
+			}),
+		},
+		"definitions": definitions,
+	}
+}
+
+// schema for an object with the given properties, where keys can also be
+// written in a different case, since encoding/json accepts them either way
+func caseless(props map[string]interface{}) map[string]interface{} {
+	patterns := map[string]interface{}{}
+	for name, prop := range props {
+		patterns[keyPattern(name)] = prop
+	}
+	return map[string]interface{}{
+		"type":              "object",
+		"properties":        props,
+		"patternProperties": patterns,
+	}
+}
+
+// pattern which matches a key in any combination of upper and lower case
+func keyPattern(name string) string {
+	pattern := "^"
+	for _, ch := range name {
+		lower, upper := strings.ToLower(string(ch)), strings.ToUpper(string(ch))
+		if lower == upper {
+			pattern += regexp.QuoteMeta(lower)
+		} else {
+			pattern += "[" + lower + upper + "]"
+		}
+	}
+	return pattern + "$"
+}
+
+// schema for a "gadget.Pin" string, where Pin must be one of the given names
+func pinSchema(names map[string]bool, description string) map[string]interface{} {
+	alts := []string{}
+	for name := range names {
+		alt := regexp.QuoteMeta(strings.TrimSuffix(name, ":"))
+		if strings.HasSuffix(name, ":") {
+			alt += ":.*"
+		}
+		alts = append(alts, alt)
+	}
+	sort.Strings(alts)
+	return map[string]interface{}{
+		"type":        "string",
+		"pattern":     `^[^.]+\.(` + strings.Join(alts, "|") + `)$`,
+		"description": description,
+	}
+}
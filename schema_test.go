@@ -0,0 +1,41 @@
+package flow_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+// follow a path of keys into nested JSON objects
+func jsonPath(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		v = v.(map[string]interface{})[k]
+	}
+	return v
+}
+
+func ExampleSchema() {
+	registry := map[string]func() flow.Circuitry{
+		"Repeater": flow.Registry["Repeater"],
+		"FanOut":   flow.Registry["FanOut"],
+	}
+	schema := flow.Schema(registry)
+	defs := jsonPath(schema, "definitions")
+	props := jsonPath(defs, "circuit", "properties")
+	for _, v := range []interface{}{
+		jsonPath(props, "gadgets", "items", "properties", "type", "enum"),
+		jsonPath(props, "wires", "items", "properties", "from", "pattern"),
+		jsonPath(props, "wires", "items", "properties", "to", "pattern"),
+		jsonPath(props, "wires", "items", "allOf"),
+	} {
+		data, _ := json.Marshal(v)
+		fmt.Println(string(data))
+	}
+	// Output:
+	// ["FanOut","Repeater"]
+	// "^[^.]+\\.(Out|Out:.*)$"
+	// "^[^.]+\\.(In|Num)$"
+	// [{"not":{"propertyNames":{"not":{"pattern":"^[fF][rR][oO][mM]$"}}}},{"not":{"propertyNames":{"not":{"pattern":"^[tT][oO]$"}}}}]
+}
@@ -0,0 +1,361 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of messages buffered for each client of a stream.
+const streamCapacity = 100
+
+// A Server is an HTTP handler to inspect and control running circuits. It can
+// be mounted anywhere, using http.StripPrefix. All responses are JSON:
+//
+//	GET  /registry                  catalog of all registry entries
+//	GET  /circuits                  names of all circuits, and whether running
+//	GET  /circuits/NAME             status tree, with wire queues and counts
+//	POST /circuits/NAME?type=T      create and start circuit T (default NAME)
+//	POST /circuits/NAME/stop        stop a circuit and wait until it has ended
+//	POST /circuits/NAME/inject?pin=g.In    send the JSON body to an input pin
+//	GET  /circuits/NAME/tap?pin=g.In&count=N&timeout=D
+//	                                collect messages sent to an input pin
+//	GET  /circuits/NAME/stream?pin=g.In&pin=...&tag=T&match=S&rate=N
+//	                                server-sent events, see stream
+type Server struct {
+	mutex    sync.Mutex
+	circuits map[string]*served
+}
+
+// a circuit which is known to the server
+type served struct {
+	circuit *Circuit
+	running bool
+	done    chan struct{} // closed when the circuit has finished running
+}
+
+// NewServer returns a server without any circuits.
+func NewServer() *Server {
+	return &Server{circuits: map[string]*served{}}
+}
+
+// Attach a circuit under the given name and start it in the background. The
+// returned channel is closed once the circuit has finished running.
+func (s *Server) Attach(name string, c *Circuit) <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.circuits[name] = &served{circuit: c}
+	s.start(name)
+	return s.circuits[name].done
+}
+
+// run a circuit in the background, keeping track of when it is done
+func (s *Server) start(name string) {
+	sc := s.circuits[name]
+	sc.running = true
+	sc.done = make(chan struct{})
+	go func() {
+		sc.circuit.Run()
+		s.mutex.Lock()
+		sc.running = false
+		s.mutex.Unlock()
+		close(sc.done)
+	}()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "registry":
+		s.reply(w, http.StatusOK, Catalog(Registry))
+	case len(path) == 1 && path[0] == "circuits":
+		s.mutex.Lock()
+		list := map[string]bool{}
+		for name, sc := range s.circuits {
+			list[name] = sc.running
+		}
+		s.mutex.Unlock()
+		s.reply(w, http.StatusOK, list)
+	case len(path) == 2 && path[0] == "circuits" && r.Method == "POST":
+		s.create(w, path[1], r.FormValue("type"))
+	case len(path) >= 2 && path[0] == "circuits":
+		s.mutex.Lock()
+		sc := s.circuits[path[1]]
+		s.mutex.Unlock()
+		if sc == nil {
+			s.fail(w, http.StatusNotFound, "circuit not found: "+path[1])
+			return
+		}
+		action := ""
+		if len(path) == 3 {
+			action = path[2]
+		}
+		s.control(w, r, sc, action)
+	default:
+		s.fail(w, http.StatusNotFound, "not found: "+r.URL.Path)
+	}
+}
+
+// create a new circuit from the registry and start it
+func (s *Server) create(w http.ResponseWriter, name, typ string) {
+	if typ == "" {
+		typ = name
+	}
+	factory, ok := Registry[typ]
+	if !ok {
+		s.fail(w, http.StatusNotFound, "not found in registry: "+typ)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sc := s.circuits[name]; sc != nil && sc.running {
+		s.fail(w, http.StatusConflict, "already running: "+name)
+		return
+	}
+	cy, err := fromRegistry(typ, factory)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c, ok := cy.(*Circuit)
+	if !ok {
+		s.fail(w, http.StatusBadRequest, "not a circuit: "+typ)
+		return
+	}
+	s.circuits[name] = &served{circuit: c}
+	s.start(name)
+	s.reply(w, http.StatusCreated, name)
+}
+
+// create a registry entry, definitions are checked first, since a circuit
+// which can't be built makes its factory exit, and panics are turned into an
+// error, so that no request can bring down the server
+func fromRegistry(typ string, factory func() Circuitry) (cy Circuitry, err error) {
+	if raw := circuitDefs[typ]; raw != nil {
+		conf, err := parseDefinition(raw, nil)
+		if err == nil && conf.Process == nil {
+			err = conf.validate(nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", typ, err)
+		}
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s: %v", typ, e)
+		}
+	}()
+	return factory(), nil
+}
+
+// handle requests for one specific circuit
+func (s *Server) control(w http.ResponseWriter, r *http.Request, sc *served, action string) {
+	c := sc.circuit
+	switch {
+	case action == "" && r.Method == "GET":
+		st := c.Status()
+		s.mutex.Lock()
+		st.Running = sc.running
+		s.mutex.Unlock()
+		s.reply(w, http.StatusOK, st)
+	case action == "stop" && r.Method == "POST":
+		c.Stop()
+		select {
+		case <-sc.done: // reply once all its gadgets have ended
+			s.reply(w, http.StatusOK, "stopped")
+		case <-r.Context().Done():
+		}
+	case action == "inject" && r.Method == "POST":
+		var m Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			s.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := c.Inject(r.FormValue("pin"), m); err != nil {
+			s.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.reply(w, http.StatusOK, "sent")
+	case action == "tap" && r.Method == "GET":
+		count, timeout, err := tapParams(r)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		tap, untap, err := c.Tap(r.FormValue("pin"), count)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer untap()
+		msgs := []Message{}
+		expired := time.After(timeout)
+	collect:
+		for len(msgs) < count {
+			select {
+			case m := <-tap:
+				msgs = append(msgs, m)
+			case <-expired:
+				break collect
+			}
+		}
+		s.reply(w, http.StatusOK, msgs)
+	case action == "stream" && r.Method == "GET":
+		s.stream(w, r, c)
+	default:
+		s.fail(w, http.StatusNotFound, "not found: "+r.URL.Path)
+	}
+}
+
+// parse the optional count and timeout of a tap request
+func tapParams(r *http.Request) (int, time.Duration, error) {
+	count, timeout := 10, time.Second
+	if s := r.FormValue("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("bad count: %s", s)
+		}
+		count = n
+	}
+	if s := r.FormValue("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		timeout = d
+	}
+	return count, timeout, nil
+}
+
+// a message seen on one of the streamed pins
+type streamEvent struct {
+	Pin     string  `json:"pin"`
+	Msg     Message `json:"msg"`
+	Dropped int     `json:"dropped,omitempty"` // messages skipped before this one
+}
+
+// Stream messages sent to one or more input pins as server-sent events, each
+// with a JSON-encoded streamEvent as data. Clients can select Tag messages
+// with the "tag" parameter, require a substring in the JSON encoding of the
+// message with "match", and limit the number of events per second with
+// "rate". Messages are dropped instead of ever holding up the circuit, when
+// the client is slow or when the rate is exceeded.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, c *Circuit) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.fail(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	r.ParseForm()
+	pins := r.Form["pin"]
+	if len(pins) == 0 {
+		s.fail(w, http.StatusBadRequest, "no pin specified")
+		return
+	}
+	tag, match := r.FormValue("tag"), r.FormValue("match")
+	rate := 0.0
+	if v := r.FormValue("rate"); v != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || rate <= 0 {
+			s.fail(w, http.StatusBadRequest, "bad rate: "+v)
+			return
+		}
+	}
+
+	// merge all the taps into one channel, without ever blocking a tap
+	events := make(chan streamEvent, streamCapacity)
+	quit := make(chan struct{})
+	defer close(quit)
+	var dropped int64
+	for _, pin := range pins {
+		tap, untap, err := c.Tap(pin, streamCapacity)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer untap()
+		go func(pin string) {
+			for {
+				select {
+				case m := <-tap:
+					select {
+					case events <- streamEvent{Pin: pin, Msg: m}:
+					default:
+						atomic.AddInt64(&dropped, 1)
+					}
+				case <-quit:
+					return
+				}
+			}
+		}(pin)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	tokens, last := rate, time.Now()
+	for {
+		var ev streamEvent
+		select {
+		case ev = <-events:
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if t, ok := ev.Msg.(Tag); tag != "" && (!ok || t.Tag != tag) {
+			continue
+		}
+		data, err := json.Marshal(ev.Msg)
+		if err != nil || !strings.Contains(string(data), match) {
+			continue
+		}
+		if rate > 0 {
+			// token bucket, allowing bursts of up to one second's worth
+			now := time.Now()
+			tokens += now.Sub(last).Seconds() * rate
+			if tokens > rate {
+				tokens = rate
+			}
+			last = now
+			if tokens < 1 {
+				atomic.AddInt64(&dropped, 1)
+				continue
+			}
+			tokens--
+		}
+		ev.Dropped = int(atomic.SwapInt64(&dropped, 0))
+		if data, err = json.Marshal(ev); err != nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) reply(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, msg string) {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,159 @@
+package flow_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jcw/flow"
+)
+
+// keeps sending zeros until the circuit is stopped
+type zeros struct {
+	flow.Gadget
+	Out flow.Output
+}
+
+func (g *zeros) Run() {
+	for {
+		select {
+		case <-time.After(time.Millisecond):
+			g.Out.Send(0)
+		case <-g.Done():
+			return
+		}
+	}
+}
+
+// reports each string printed by a stringPrinter
+var printed = make(chan string, 10)
+
+// prints only the strings it receives
+type stringPrinter struct {
+	flow.Gadget
+	In flow.Input
+}
+
+func (g *stringPrinter) Run() {
+	for m := range g.In {
+		if s, ok := m.(string); ok {
+			fmt.Println(s)
+			printed <- s
+		}
+	}
+}
+
+func ExampleServer() {
+	flow.Registry["serverDemo"] = func() flow.Circuitry {
+		c := flow.NewCircuit()
+		c.AddCircuitry("z", &zeros{})
+		c.AddCircuitry("p", &stringPrinter{})
+		c.Connect("z.Out", "p.In", 0)
+		return c
+	}
+	ts := httptest.NewServer(flow.NewServer())
+	defer ts.Close()
+
+	call := func(method, path, body string) string {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err.Error()
+		}
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		return fmt.Sprintf("%d %s", res.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	fmt.Println(call("POST", "/circuits/demo?type=serverDemo", ""))
+	fmt.Println(call("POST", "/circuits/demo?type=serverDemo", ""))
+	sent := call("POST", "/circuits/demo/inject?pin=p.In", `"hello"`)
+	<-printed
+	fmt.Println(sent)
+	fmt.Println(call("GET", "/circuits/demo/tap?pin=p.In&count=3", ""))
+	fmt.Println(call("GET", "/circuits/demo/tap?pin=p.Out", ""))
+	status := call("GET", "/circuits/demo", "")
+	fmt.Println(strings.Contains(status, `"running":true`),
+		strings.Contains(status, `"name":"p","type":"*flow_test.stringPrinter"`))
+	fmt.Println(call("POST", "/circuits/demo/stop", ""))
+	fmt.Println(call("GET", "/circuits", ""))
+	fmt.Println(call("GET", "/nowhere", ""))
+	// Output:
+	// 201 "demo"
+	// 409 {"error":"already running: demo"}
+	// hello
+	// 200 "sent"
+	// 200 [0,0,0]
+	// 400 {"error":"no wire to: p.Out"}
+	// true true
+	// 200 "stopped"
+	// 200 {"demo":false}
+	// 404 {"error":"not found: /nowhere"}
+}
+
+func ExampleServer_badCircuit() {
+	dir, _ := ioutil.TempDir("", "flow")
+	defer os.RemoveAll(dir)
+	defs := filepath.Join(dir, "defs.json")
+	ioutil.WriteFile(defs, []byte(`{
+		"serverBad": {
+			"gadgets": [{ "name": "p", "type": "Pipe" }],
+			"wires": [{ "from": "p.Out", "to": "x.In" }]
+		}
+	}`), 0666)
+	flow.AddToRegistry(defs)
+	flow.Registry["serverPanic"] = func() flow.Circuitry { panic("oops") }
+	defer delete(flow.Registry, "serverPanic")
+	ts := httptest.NewServer(flow.NewServer())
+	defer ts.Close()
+
+	for _, typ := range []string{"serverBad", "serverPanic"} {
+		res, _ := http.Post(ts.URL+"/circuits/x?type="+typ, "", nil)
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		fmt.Println(res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	// Output:
+	// 500 {"error":"serverBad: gadget not found for: x.In"}
+	// 500 {"error":"serverPanic: oops"}
+}
+
+var dropCount = regexp.MustCompile(`,"dropped":\d+`)
+
+func ExampleServer_stream() {
+	server := flow.NewServer()
+	c := flow.NewCircuit()
+	c.AddCircuitry("z", &zeros{})
+	c.AddCircuitry("p", &stringPrinter{})
+	c.Connect("z.Out", "p.In", 0)
+	server.Attach("demo", c)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/circuits/demo/stream?pin=p.In&rate=100")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(res.Header.Get("Content-Type"))
+	lines := bufio.NewScanner(res.Body)
+	for n := 0; n < 2 && lines.Scan(); {
+		if line := lines.Text(); line != "" {
+			fmt.Println(dropCount.ReplaceAllString(line, ""))
+			n++
+		}
+	}
+	res.Body.Close()
+	c.Stop()
+	// Output:
+	// text/event-stream
+	// data: {"pin":"p.In","msg":0}
+	// data: {"pin":"p.In","msg":0}
+}
@@ -0,0 +1,115 @@
+package flow
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// Gadgets which keep internal state can implement this interface to have it
+// saved by Checkpoint and reloaded by Restore.
+type Stateful interface {
+	SaveState() ([]byte, error)
+	LoadState(data []byte) error
+}
+
+// collect the state of all stateful gadgets, with nested names as "a.b.c"
+func (c *Circuit) saveStates(prefix string, states map[string][]byte) error {
+	for name, g := range c.gadgets {
+		switch cy := g.circuitry.(type) {
+		case *Circuit:
+			if err := cy.saveStates(prefix+name+".", states); err != nil {
+				return err
+			}
+		case Stateful:
+			data, err := cy.SaveState()
+			if err != nil {
+				return err
+			}
+			states[prefix+name] = data
+		}
+	}
+	return nil
+}
+
+// Checkpoint writes the state of all stateful gadgets as JSON, recursively.
+// This should only be called while the circuit is not running.
+func (c *Circuit) Checkpoint(w io.Writer) error {
+	states := map[string][]byte{}
+	if err := c.saveStates("", states); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(states)
+}
+
+// CheckpointFile saves the state of all stateful gadgets to a file.
+func (c *Circuit) CheckpointFile(filename string) error {
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.Checkpoint(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename) // never leave a half-written checkpoint
+}
+
+// find a stateful gadget by its nested name
+func (c *Circuit) statefulOf(path string) Stateful {
+	if g, ok := c.gadgets[path]; ok {
+		s, _ := g.circuitry.(Stateful)
+		return s
+	}
+	for name, g := range c.gadgets {
+		if sub, ok := g.circuitry.(*Circuit); ok {
+			if len(path) > len(name) && path[:len(name)+1] == name+"." {
+				return sub.statefulOf(path[len(name)+1:])
+			}
+		}
+	}
+	return nil
+}
+
+// Restore reloads gadget states saved by Checkpoint, call this before Run.
+// States of gadgets which no longer exist in the circuit are ignored.
+func (c *Circuit) Restore(r io.Reader) error {
+	var states map[string][]byte
+	if err := json.NewDecoder(r).Decode(&states); err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := c.statefulOf(name)
+		if s == nil {
+			glog.Warningln("no stateful gadget for:", name)
+			continue
+		}
+		if err := s.LoadState(states[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreFile reloads gadget states from a file saved by CheckpointFile.
+func (c *Circuit) RestoreFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Restore(f)
+}
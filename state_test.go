@@ -0,0 +1,39 @@
+package flow_test
+
+import (
+	"bytes"
+
+	"github.com/jcw/flow"
+	_ "github.com/jcw/flow/gadgets"
+)
+
+func counterCircuit() *flow.Circuit {
+	sub := flow.NewCircuit()
+	sub.Add("c", "Counter")
+	sub.Label("In", "c.In")
+	sub.Label("Out", "c.Out")
+
+	g := flow.NewCircuit()
+	g.AddCircuitry("sub", sub)
+	g.Add("p", "Printer")
+	g.Connect("sub.Out", "p.In", 0)
+	return g
+}
+
+func ExampleCircuit_Checkpoint() {
+	var buf bytes.Buffer
+
+	g1 := counterCircuit()
+	g1.Feed("sub.In", "abc")
+	g1.Feed("sub.In", "def")
+	g1.Run()
+	g1.Checkpoint(&buf)
+
+	g2 := counterCircuit()
+	g2.Restore(&buf)
+	g2.Feed("sub.In", "ghi")
+	g2.Run()
+	// Output:
+	// 2
+	// 3
+}
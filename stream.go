@@ -0,0 +1,155 @@
+//go:build go1.18
+
+package flow
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/glog"
+)
+
+// A Stream builds a linear circuit one stage at a time, with the type of the
+// messages between stages checked at compile time. Each stage is an ordinary
+// gadget, so the result can be described, tapped, and nested as any circuit:
+//
+//	odd := flow.Source(1, 2, 3, 4, 5).
+//		Filter(func(n int) bool { return n%2 == 1 }).
+//		Map(func(n int) int { return n * 10 })
+//	flow.Batch(odd, 2).Sink(func(b []int) { fmt.Println(b) }).Run()
+//
+// Methods can't introduce new type parameters in Go, so stages which change
+// the type of the messages are functions: Map (not the method) and Batch.
+//
+// A stream is extended once: adding a second stage to the same one panics,
+// since the output of a stage can only be connected to one next stage.
+type Stream[T any] struct {
+	c        *Circuit
+	last     string // name of the last stage added so far
+	extended bool   // set once a stage has been added after the last one
+}
+
+// a gadget which runs one stage of a stream
+type streamStage struct {
+	Gadget
+	In  Input
+	Out Output
+
+	run func(in Input, out Output)
+}
+
+func (g *streamStage) Run() {
+	g.run(g.In, g.Out)
+}
+
+// add a stage to the circuit, connected to the previous one, if any
+func addStage[T, U any](s *Stream[T], kind string, run func(in Input, out Output)) *Stream[U] {
+	if s.extended {
+		panic("flow: stream already has a stage after " + s.last)
+	}
+	s.extended = true
+	name := fmt.Sprintf("%s%d", kind, len(s.c.gadgets)+1)
+	s.c.AddCircuitry(name, &streamStage{run: run})
+	if s.last != "" {
+		s.c.Connect(s.last+".Out", name+".In", 0)
+	}
+	return &Stream[U]{c: s.c, last: name}
+}
+
+// the item in a message, messages of another type are logged and skipped,
+// nil is passed on as the zero value
+func itemOf[T any](m Message) (T, bool) {
+	v, ok := m.(T)
+	if !ok && m != nil {
+		glog.Warningf("stream: dropped %T, expected %v", m,
+			reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return v, ok || m == nil
+}
+
+// Source starts a stream which sends out the given items.
+func Source[T any](items ...T) *Stream[T] {
+	return SourceFunc(func(send func(T)) {
+		for _, item := range items {
+			send(item)
+		}
+	})
+}
+
+// SourceFunc starts a stream with items generated by a function, which can
+// call send as often as needed. The stream ends when the function returns.
+func SourceFunc[T any](gen func(send func(T))) *Stream[T] {
+	s := &Stream[T]{c: NewCircuit()}
+	return addStage[T, T](s, "source", func(in Input, out Output) {
+		gen(func(item T) { out.Send(item) })
+	})
+}
+
+// Map adds a stage which replaces each item by the result of a function, of
+// a possibly different type.
+func Map[T, U any](s *Stream[T], f func(T) U) *Stream[U] {
+	return addStage[T, U](s, "map", func(in Input, out Output) {
+		for m := range in {
+			if v, ok := itemOf[T](m); ok {
+				out.Send(f(v))
+			}
+		}
+	})
+}
+
+// Map adds a stage which replaces each item by the result of a function.
+func (s *Stream[T]) Map(f func(T) T) *Stream[T] {
+	return Map(s, f)
+}
+
+// Filter adds a stage which only passes on the items for which p is true.
+func (s *Stream[T]) Filter(p func(T) bool) *Stream[T] {
+	return addStage[T, T](s, "filter", func(in Input, out Output) {
+		for m := range in {
+			if v, ok := itemOf[T](m); ok && p(v) {
+				out.Send(v)
+			}
+		}
+	})
+}
+
+// Batch adds a stage which collects items into slices of n, the last one may
+// be shorter.
+func Batch[T any](s *Stream[T], n int) *Stream[[]T] {
+	return addStage[T, []T](s, "batch", func(in Input, out Output) {
+		batch := make([]T, 0, n)
+		for m := range in {
+			v, ok := itemOf[T](m)
+			if !ok {
+				continue
+			}
+			if batch = append(batch, v); len(batch) >= n {
+				out.Send(batch)
+				batch = make([]T, 0, n)
+			}
+		}
+		if len(batch) > 0 {
+			out.Send(batch)
+		}
+	})
+}
+
+// Sink ends the stream with a stage which calls a function for each item,
+// and returns the resulting circuit.
+func (s *Stream[T]) Sink(f func(T)) *Circuit {
+	addStage[T, T](s, "sink", func(in Input, out Output) {
+		for m := range in {
+			if v, ok := itemOf[T](m); ok {
+				f(v)
+			}
+		}
+	})
+	return s.c
+}
+
+// Circuit returns the circuit built so far, with the output of the last stage
+// available as its "Out" pin, e.g. for nesting it in another circuit.
+func (s *Stream[T]) Circuit() *Circuit {
+	s.c.Label("Out", s.last+".Out")
+	return s.c
+}
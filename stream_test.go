@@ -0,0 +1,69 @@
+//go:build go1.18
+
+package flow_test
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jcw/flow"
+)
+
+func ExampleSource() {
+	odd := flow.Source(1, 2, 3, 4, 5, 6, 7).
+		Filter(func(n int) bool { return n%2 == 1 }).
+		Map(func(n int) int { return n * 10 })
+	flow.Batch(odd, 3).
+		Sink(func(b []int) { fmt.Println(len(b), b) }).
+		Run()
+	// Output:
+	// 3 [10 30 50]
+	// 1 [70]
+}
+
+func ExampleMap() {
+	s := flow.Map(flow.Source(1, 22, 333), strconv.Itoa)
+	flow.Map(s, func(s string) int { return len(s) }).
+		Sink(func(n int) { fmt.Println(n) }).
+		Run()
+	// Output:
+	// 1
+	// 2
+	// 3
+}
+
+func ExampleStream_Circuit() {
+	s := flow.SourceFunc(func(send func(string)) {
+		send("a")
+		send("b")
+	}).Map(func(s string) string { return s + s })
+
+	g := flow.NewCircuit()
+	g.AddCircuitry("s", s.Circuit())
+	g.Add("p", "Printer")
+	g.Connect("s.Out", "p.In", 0)
+	g.Run()
+	// Output:
+	// aa
+	// bb
+}
+
+func ExampleStream_nil() {
+	flow.Source[error](nil, fmt.Errorf("oops")).
+		Sink(func(err error) { fmt.Println(err) }).
+		Run()
+	// Output:
+	// <nil>
+	// oops
+}
+
+func ExampleStream_reuse() {
+	s := flow.Source(1, 2, 3)
+	s.Map(func(n int) int { return n * 2 })
+	defer func() {
+		fmt.Println(recover())
+	}()
+	s.Map(func(n int) int { return n * 3 })
+	// Output:
+	// flow: stream already has a stage after source1
+}
@@ -0,0 +1,14 @@
+// A plugin claiming to be built for an older version of flow, for testing.
+package main
+
+import "github.com/jcw/flow"
+
+var FlowVersion = "0.0.1"
+
+type oldGadget struct{ flow.Gadget }
+
+func (g *oldGadget) Run() {}
+
+func init() {
+	flow.Registry["OldPluginGadget"] = func() flow.Circuitry { return new(oldGadget) }
+}
@@ -0,0 +1,239 @@
+package flow
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Largest WebSocket message accepted, counting all its frames, a client could
+// otherwise grow one message without bound with continuation frames.
+const maxWebSocketMessage = 16 << 20
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// A WebSocket is a minimal RFC 6455 connection for exchanging text messages,
+// just enough to talk to browser-based tools without external dependencies.
+type WebSocket struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	client bool       // clients must mask the frames they send
+	mutex  sync.Mutex // serialises writes
+}
+
+// accept an HTTP request as WebSocket, with an optional sub-protocol, from
+// browsers only if the page is on the same host or one of the given origins
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, protocol string, origins []string) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket request")
+	}
+	if origin := r.Header.Get("Origin"); !originAllowed(origin, r.Host, origins) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("origin not allowed: %s", origin)
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("cannot hijack connection")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n", webSocketAccept(key))
+	for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if protocol != "" && strings.TrimSpace(p) == protocol {
+			fmt.Fprintf(rw, "Sec-WebSocket-Protocol: %s\r\n", protocol)
+			break
+		}
+	}
+	fmt.Fprintf(rw, "\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocket{conn: conn, rw: rw}, nil
+}
+
+// requests without origin do not come from a web page, and are always allowed
+func originAllowed(origin, host string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
+}
+
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// DialWebSocket connects to a WebSocket server, given a "ws://" URL.
+func DialWebSocket(rawurl, protocol string) (*WebSocket, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest("GET", "http://"+u.Host+u.RequestURI(), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if protocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if err = req.Write(rw); err == nil {
+		err = rw.Flush()
+	}
+	var res *http.Response
+	if err == nil {
+		res, err = http.ReadResponse(rw.Reader, req)
+	}
+	if err == nil && (res.StatusCode != http.StatusSwitchingProtocols ||
+		res.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key)) {
+		err = fmt.Errorf("websocket handshake failed: %s", res.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocket{conn: conn, rw: rw, client: true}, nil
+}
+
+// ReadMessage returns the next text or binary message. Control frames are
+// handled internally, a close from the peer is reported as io.EOF.
+func (ws *WebSocket) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(ws.rw, head[:]); err != nil {
+			return nil, err
+		}
+		final, opcode := head[0]&0x80 != 0, head[0]&0x0F
+		masked, size := head[1]&0x80 != 0, uint64(head[1]&0x7F)
+		switch size {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			size = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			size = binary.BigEndian.Uint64(ext[:])
+		}
+		if size > maxWebSocketMessage ||
+			size+uint64(len(message)) > maxWebSocketMessage {
+			return nil, errors.New("websocket message too large")
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(ws.rw, payload); err != nil {
+			return nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case 0, 1, 2: // continuation, text, binary
+			message = append(message, payload...)
+			if final {
+				return message, nil
+			}
+		case 8: // close
+			ws.writeFrame(8, nil)
+			return nil, io.EOF
+		case 9: // ping
+			if err := ws.writeFrame(10, payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// WriteMessage sends a text message.
+func (ws *WebSocket) WriteMessage(data []byte) error {
+	return ws.writeFrame(1, data)
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (ws *WebSocket) Close() error {
+	ws.writeFrame(8, nil)
+	return ws.conn.Close()
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, data []byte) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	head := []byte{0x80 | opcode, 0}
+	switch n := len(data); {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xFFFF:
+		head[1] = 126
+		head = append(head, byte(n>>8), byte(n))
+	default:
+		head[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		head = append(head, ext[:]...)
+	}
+	if ws.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		head[1] |= 0x80
+		head = append(head, mask[:]...)
+		masked := make([]byte, len(data))
+		for i, b := range data {
+			masked[i] = b ^ mask[i%4]
+		}
+		data = masked
+	}
+	ws.rw.Write(head)
+	ws.rw.Write(data)
+	return ws.rw.Flush()
+}